@@ -7,6 +7,7 @@ import (
 	"github.com/houzhongjian/bigcache/app/cache-admin/module/migrate"
 	"github.com/houzhongjian/bigcache/cmd"
 	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/registry"
 )
 
 func main() {
@@ -14,11 +15,17 @@ func main() {
 	cmd := cmd.New()
 	conf.Load(cmd.Conf)
 
+	//连接注册中心.
+	reg, err := registry.New()
+	if err != nil {
+		log.Panicf("err:%+v\n", err)
+	}
+
 	//执行迁移.
-	mig := migrate.New()
+	mig := migrate.New(reg)
 	go mig.Start()
 
 	//开启web控制台.
-	srv := handler.NewAdmin()
+	srv := handler.NewAdmin(reg)
 	srv.Start()
 }
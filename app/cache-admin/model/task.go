@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
@@ -28,6 +30,10 @@ func (task *Task) SwitchTaskStatus() {
 	if task.Status == 2 {
 		task.StatusName = "执行结束"
 	}
+
+	if task.Status == 3 {
+		task.StatusName = "执行失败"
+	}
 }
 
 //TableName .
@@ -72,3 +78,24 @@ func (m *Model) UpdateTaskStatusById(taskid int, status TaskStatus) error {
 		Where("id = ?", taskid).
 		Update(map[string]interface{}{"status": status}).Error
 }
+
+//StartTaskById 将等待开始或执行失败的任务改为执行中,返回是否修改成功.
+//以任务状态作为更新条件,同一个任务同时被多次开始时只有一次成功.
+func (m *Model) StartTaskById(taskid int) (bool, error) {
+	task := Task{}
+	db := m.db.Table(task.TableName()).
+		Where("id = ? AND status IN (?)", taskid, []TaskStatus{0, 3}).
+		Update(map[string]interface{}{"status": 1})
+	return db.RowsAffected > 0, db.Error
+}
+
+//FinishTaskById 根据任务id结束任务.
+func (m *Model) FinishTaskById(taskid int, status TaskStatus) error {
+	task := Task{}
+	return m.db.Table(task.TableName()).
+		Where("id = ?", taskid).
+		Update(map[string]interface{}{
+			"status": status,
+			"end_at": time.Now().Format("2006-01-02 15:04:05"),
+		}).Error
+}
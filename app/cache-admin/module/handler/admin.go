@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,20 +9,20 @@ import (
 
 	"github.com/houzhongjian/bigcache/app/cache-admin/module/migrate"
 
-	"go.etcd.io/etcd/clientv3"
-
 	"github.com/gin-gonic/gin"
 
 	"github.com/houzhongjian/bigcache/app/cache-admin/model"
 	"github.com/houzhongjian/bigcache/base"
 	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
 	"github.com/houzhongjian/bigcache/lib/utils"
 )
 
 type Admin struct {
-	Addr  string
-	Etcd  *clientv3.Client
-	Model *model.Model
+	Addr     string
+	Registry registry.Registry //集群信息的注册中心.
+	Model    *model.Model
 }
 
 type AdminEngine interface {
@@ -38,12 +37,14 @@ type SlotParams struct {
 }
 
 //NewAdmin 返回一个Admin接口.
-func NewAdmin() AdminEngine {
+func NewAdmin(reg registry.Registry) AdminEngine {
 	var admin AdminEngine
+	loadSlotCount(reg)
+	packet.SetMaxFrameSize(uint32(conf.GetInt("max_frame_size")))
 	admin = &Admin{
-		Addr:  fmt.Sprintf(":%s", conf.GetString("addr")),
-		Etcd:  newEtcd(),
-		Model: model.New(),
+		Addr:     fmt.Sprintf(":%s", conf.GetString("addr")),
+		Registry: reg,
+		Model:    model.New(),
 	}
 	return admin
 }
@@ -79,23 +80,58 @@ func (admin *Admin) NodeHandle(c *gin.Context) {
 			return
 		}
 
+		//设置了主节点时作为从节点,主节点必须存在且不是从节点.
+		serverMaster := c.PostForm("serverMaster")
+		if len(serverMaster) > 0 {
+			if serverMaster == serverIp {
+				admin.ReturnJson(c, "主节点不能是当前节点", false)
+				return
+			}
+
+			list, err := admin.getCacheServerList()
+			if err != nil {
+				log.Printf("err:%+v\n", err)
+				admin.ReturnJson(c, "添加失败", false)
+				return
+			}
+
+			found := false
+			for _, v := range list {
+				if v.IP == serverMaster && len(v.Master) < 1 {
+					found = true
+				}
+			}
+			if !found {
+				admin.ReturnJson(c, "主节点不存在", false)
+				return
+			}
+		}
+
 		cacheServer := base.CacheServer{
-			ID:    uint(utils.ParseInt(serverId)),
-			IP:    serverIp,
-			Types: base.CACHESERVER_TYPE_NORMAL,
+			ID:     uint(utils.ParseInt(serverId)),
+			IP:     serverIp,
+			Types:  base.CACHESERVER_TYPE_NORMAL,
+			Master: serverMaster,
 		}
-		b, err := json.Marshal(cacheServer)
-		if err != nil {
+		if err := admin.Registry.PutNode(cacheServer); err != nil {
 			log.Printf("err:%+v\n", err)
 			admin.ReturnJson(c, "添加失败", false)
 			return
 		}
 
-		_, err = admin.Etcd.Put(context.Background(), fmt.Sprintf("/cacheserver/%s", serverId), string(b))
-		if err != nil {
-			log.Printf("err:%+v\n", err)
-			admin.ReturnJson(c, "添加失败", false)
-			return
+		if len(serverMaster) > 0 {
+			if err := admin.updateSlotReplicas(serverMaster); err != nil {
+				log.Printf("err:%+v\n", err)
+				admin.ReturnJson(c, "添加成功,更新插槽的从节点失败", false)
+				return
+			}
+
+			//通知节点开始同步,节点未启动时启动后从etcd中读取主节点.
+			if _, err := admin.request(serverIp, packet.REPLICA_OF, serverMaster); err != nil {
+				log.Printf("err:%+v\n", err)
+				admin.ReturnJson(c, "添加成功,通知节点同步失败:"+err.Error(), false)
+				return
+			}
 		}
 
 		admin.ReturnJson(c, "添加成功", true)
@@ -108,24 +144,32 @@ func (admin *Admin) NodeHandle(c *gin.Context) {
 		log.Printf("err:%+v\n", err)
 		return
 	}
+	admin.setReplInfo(list)
 
 	c.HTML(http.StatusOK, "node.html", map[string]interface{}{"CacheServerList": list})
 }
 
 //getCacheServerList 获取所有的cache server.
+//没有存活状态的节点显示为离线.
 func (admin *Admin) getCacheServerList() (list []base.CacheServer, err error) {
-	response, err := admin.Etcd.Get(context.Background(), "/cacheserver/", clientv3.WithPrefix())
+	nodes, err := admin.Registry.Nodes()
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		return list, err
 	}
 
-	for _, v := range response.Kvs {
-		value := string(v.Value)
-		cacheServer := base.CacheServer{}
-		if err := json.Unmarshal([]byte(value), &cacheServer); err != nil {
-			log.Printf("err:%+v\n", err)
-			return list, err
+	alive, err := admin.getAliveList()
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return list, err
+	}
+
+	for _, cacheServer := range nodes {
+		if node, ok := alive[cacheServer.IP]; ok {
+			cacheServer.Capacity = node.Capacity
+			cacheServer.Version = node.Version
+		} else if cacheServer.Types == base.CACHESERVER_TYPE_NORMAL {
+			cacheServer.Types = base.CACHESERVER_TYPE_OFFLINE
 		}
 		cacheServer.TypeName = base.SwitchCacheServerType(cacheServer.Types)
 
@@ -134,6 +178,26 @@ func (admin *Admin) getCacheServerList() (list []base.CacheServer, err error) {
 	return list, nil
 }
 
+//getAliveList 获取所有存活的cache server,key为ip.
+//cache server通过租约保持存活状态,停止心跳之后租约过期被删除.
+func (admin *Admin) getAliveList() (map[string]base.CacheServer, error) {
+	leases, err := admin.Registry.Leases(registry.LEASE_NODE)
+	if err != nil {
+		return nil, err
+	}
+
+	alive := make(map[string]base.CacheServer)
+	for ip, v := range leases {
+		cacheServer := base.CacheServer{}
+		if err := json.Unmarshal([]byte(v), &cacheServer); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+		alive[ip] = cacheServer
+	}
+	return alive, nil
+}
+
 //SlotHandle 插槽.
 func (admin *Admin) SlotHandle(c *gin.Context) {
 	if c.Request.Method == "POST" {
@@ -142,13 +206,14 @@ func (admin *Admin) SlotHandle(c *gin.Context) {
 			admin.ReturnJson(c, "所属ip不能为空", false)
 			return
 		}
+		maxSlot := int(utils.SlotCount()) - 1
 		startSlot := utils.ParseInt(c.PostForm("startSlot"))
-		if startSlot < 0 || startSlot > 1023 {
+		if startSlot < 0 || startSlot > maxSlot {
 			admin.ReturnJson(c, "插槽信息错误", false)
 			return
 		}
 		endSlot := utils.ParseInt(c.PostForm("endSlot"))
-		if startSlot < 0 || endSlot > 1023 {
+		if endSlot < 0 || endSlot > maxSlot {
 			admin.ReturnJson(c, "插槽信息错误", false)
 			return
 		}
@@ -158,21 +223,23 @@ func (admin *Admin) SlotHandle(c *gin.Context) {
 			return
 		}
 
+		cacheServer, err := admin.getCacheServerList()
+		if err != nil {
+			log.Printf("err:%+v\n", err)
+			admin.ReturnJson(c, "设置插槽失败", false)
+			return
+		}
+		replicas := base.ReplicasOf(cacheServer, serverIp)
+
 		for i := startSlot; i <= endSlot; i++ {
-			key := fmt.Sprintf("/slot/%d", i)
 			slot := base.Slot{
-				ID:    i,
-				Types: base.SLOT_TYPE_NORMAL,
-				IP:    serverIp,
+				ID:       i,
+				Types:    base.SLOT_TYPE_NORMAL,
+				IP:       serverIp,
+				Replicas: replicas,
 			}
 
-			b, err := json.Marshal(slot)
-			if err != nil {
-				admin.ReturnJson(c, "设置插槽失败", false)
-				return
-			}
-			_, err = admin.Etcd.Put(context.Background(), key, string(b))
-			if err != nil {
+			if err := admin.Registry.PutSlot(slot); err != nil {
 				log.Printf("err:%+v\n", err)
 				admin.ReturnJson(c, "设置插槽失败", false)
 				return
@@ -214,25 +281,20 @@ func (admin *Admin) SlotHandle(c *gin.Context) {
 	data := map[string]interface{}{
 		"CacheServerList": cacheServer,
 		"SlotList":        slotData,
+		"MaxSlot":         utils.SlotCount() - 1,
 	}
 	c.HTML(http.StatusOK, "slot.html", data)
 }
 
 //getSlotList 获取所有的插槽信息.
 func (admin *Admin) getSlotList() (list []base.Slot, err error) {
-	response, err := admin.Etcd.Get(context.Background(), "/slot/", clientv3.WithPrefix())
+	slots, _, err := admin.Registry.Slots()
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		return list, err
 	}
 
-	for _, v := range response.Kvs {
-		value := string(v.Value)
-		slot := base.Slot{}
-		if err := json.Unmarshal([]byte(value), &slot); err != nil {
-			log.Printf("err:%+v\n", err)
-			return list, err
-		}
+	for _, slot := range slots {
 		slot.TypeName = base.SwitchSlotType(slot.Types)
 
 		list = append(list, slot)
@@ -244,7 +306,7 @@ func (admin *Admin) getSlotList() (list []base.Slot, err error) {
 func (admin *Admin) MigrateHandle(c *gin.Context) {
 	if c.Request.Method == "POST" {
 		slotid := utils.ParseInt(c.PostForm("slotid"))
-		if slotid < 0 || slotid > 1023 {
+		if slotid < 0 || slotid >= int(utils.SlotCount()) {
 			admin.ReturnJson(c, "插槽id错误", false)
 			return
 		}
@@ -259,7 +321,7 @@ func (admin *Admin) MigrateHandle(c *gin.Context) {
 
 		//判断当前插槽的状态.
 		if slot.Types == base.SLOT_TYPE_MIGRATE {
-			admin.ReturnJson(c, "当前插槽已经处于迁移状态", false)
+			admin.ReturnJson(c, "当前插槽已经处于迁移状态, 失败的任务可以重新开始", false)
 			return
 		}
 
@@ -312,22 +374,12 @@ func (admin *Admin) MigrateHandle(c *gin.Context) {
 }
 
 func (admin *Admin) getIPbySlotid(slotid int) (slot base.Slot, err error) {
-	response, err := admin.Etcd.Get(context.Background(), fmt.Sprintf("/slot/%d", slotid))
+	slot, err = admin.Registry.GetSlot(slotid)
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		return slot, err
 	}
 
-	var data []byte
-	for _, item := range response.Kvs {
-		data = item.Value
-	}
-
-	if err := json.Unmarshal(data, &slot); err != nil {
-		log.Printf("err:%+v\n", err)
-		return slot, err
-	}
-
 	return slot, nil
 }
 
@@ -347,35 +399,62 @@ func (admin *Admin) StartMigrateHandle(c *gin.Context) {
 		return
 	}
 
-	//更改插槽的状态为迁移状态.
-	//插槽新增迁移ip .
-	slot := base.Slot{
-		ID:    task.SlotID,
-		Types: base.SLOT_TYPE_MIGRATE,
-		IP:    task.MigrateIP,
-		NewIP: task.TargetIP,
+	//只有等待开始和执行失败的任务才可以执行.
+	if task.Status != 0 && task.Status != 3 {
+		admin.ReturnJson(c, "当前任务已经开始", false)
+		return
 	}
-	b, err := json.Marshal(slot)
+
+	//插槽必须仍然属于原节点,失败的任务重新开始时插槽处于当前任务的迁移状态.
+	current, err := admin.getIPbySlotid(task.SlotID)
 	if err != nil {
-		log.Printf("err:%+v\n", err)
 		admin.ReturnJson(c, "请求任务信息失败", false)
 		return
 	}
-	_, err = admin.Etcd.Put(context.Background(), fmt.Sprintf("/slot/%d", task.SlotID), string(b))
+	if current.IP != task.MigrateIP || (current.Types == base.SLOT_TYPE_MIGRATE && current.NewIP != task.TargetIP) {
+		admin.ReturnJson(c, "插槽已经不属于当前任务", false)
+		return
+	}
+
+	cacheServer, err := admin.getCacheServerList()
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		admin.ReturnJson(c, "请求任务信息失败", false)
 		return
 	}
 
-	//更改任务状态.
-	if err := admin.Model.UpdateTaskStatusById(taskid, 1); err != nil {
+	//更改任务状态,只有状态仍为等待开始或执行失败时才能修改成功,避免同一个任务被同时开始多次.
+	ok, err := admin.Model.StartTaskById(taskid)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
 		admin.ReturnJson(c, err.Error(), false)
 		return
 	}
+	if !ok {
+		admin.ReturnJson(c, "当前任务已经开始", false)
+		return
+	}
+
+	//更改插槽的状态为迁移状态.
+	//插槽新增迁移ip .
+	slot := base.Slot{
+		ID:       task.SlotID,
+		Types:    base.SLOT_TYPE_MIGRATE,
+		IP:       task.MigrateIP,
+		NewIP:    task.TargetIP,
+		Replicas: base.ReplicasOf(cacheServer, task.MigrateIP),
+	}
+	if err := admin.Registry.PutSlot(slot); err != nil {
+		log.Printf("err:%+v\n", err)
+		//插槽没有修改,恢复任务原来的状态.
+		if err := admin.Model.UpdateTaskStatusById(taskid, task.Status); err != nil {
+			log.Printf("err:%+v\n", err)
+		}
+		admin.ReturnJson(c, "请求任务信息失败", false)
+		return
+	}
 
-	//TODO 执行迁移任务.
-	//通知对应服务，开始准备迁移.
-	migrate.List <- slot
+	//通知对应服务，开始迁移.
+	migrate.List <- task
 	admin.ReturnJson(c, "开始迁移", true)
 }
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/registry"
+)
+
+//getProxyList 获取所有存活的proxy,按连接的客户端数量从少到多排序.
+//proxy通过租约保持注册信息,停止心跳之后租约过期被删除.
+func (admin *Admin) getProxyList() (list []base.ProxyServer, err error) {
+	leases, err := admin.Registry.Leases(registry.LEASE_PROXY)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return list, err
+	}
+
+	for _, v := range leases {
+		proxy := base.ProxyServer{}
+		if err := json.Unmarshal([]byte(v), &proxy); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+		list = append(list, proxy)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Clients != list[j].Clients {
+			return list[i].Clients < list[j].Clients
+		}
+		return list[i].Addr < list[j].Addr
+	})
+	return list, nil
+}
+
+//ProxyHandle proxy列表.
+func (admin *Admin) ProxyHandle(c *gin.Context) {
+	list, err := admin.getProxyList()
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "proxy.html", map[string]interface{}{"ProxyList": list})
+}
+
+//DiscoveryHandle 客户端获取可用的proxy地址,连接数少的排在前面.
+func (admin *Admin) DiscoveryHandle(c *gin.Context) {
+	list, err := admin.getProxyList()
+	if err != nil {
+		admin.ReturnJson(c, "获取proxy失败", false)
+		return
+	}
+
+	addrs := make([]string, 0, len(list))
+	for _, proxy := range list {
+		addrs = append(addrs, proxy.Addr)
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"msg":    "",
+		"status": len(addrs) > 0,
+		"data":   addrs,
+	})
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/registry"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//loadSlotCount 从注册中心加载集群的插槽数量.
+func loadSlotCount(reg registry.Registry) {
+	utils.SetSlotCount(uint32(conf.GetInt("slot_count")))
+	count, err := reg.SlotCount(utils.SlotCount())
+	if err != nil {
+		log.Panicf("err:%+v\n", err)
+	}
+	utils.SetSlotCount(count)
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//request 向cache server发送一个请求并读取返回结果.
+func (admin *Admin) request(ip string, num packet.BigcacheProtocol, args ...string) (string, error) {
+	conn, err := net.DialTimeout("tcp4", ip, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second * 3))
+	h, err := packet.Auth(conn, conf.GetString("auth_secret"), packet.CAP_CHECKSUM)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write(h.NewRequest(0, packet.EncodeArgs(args...), num)); err != nil {
+		return "", err
+	}
+
+	pkt, err := h.ParseResponse(conn)
+	if err != nil {
+		return "", err
+	}
+	if pkt.Err != errcode.NO_ERROR {
+		return "", errors.New(pkt.Msg)
+	}
+
+	return pkt.Msg, nil
+}
+
+//replInfo 获取cache server的复制状态.
+func (admin *Admin) replInfo(ip string) (map[string]string, error) {
+	msg, err := admin.request(ip, packet.REPL_INFO)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := packet.DecodeArgs([]byte(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]string)
+	for i := 0; i+1 < len(list); i += 2 {
+		info[list[i]] = list[i+1]
+	}
+	return info, nil
+}
+
+//setReplInfo 获取每个节点的复制序号和复制延迟.
+//从节点的延迟为落后主节点的日志条数及最近一次收到主节点数据的时间,主节点显示每个从节点落后的日志条数.
+func (admin *Admin) setReplInfo(list []base.CacheServer) {
+	for i := range list {
+		if list[i].Types == base.CACHESERVER_TYPE_OFFLINE {
+			list[i].Lag = "-"
+			continue
+		}
+
+		info, err := admin.replInfo(list[i].IP)
+		if err != nil {
+			list[i].Lag = "无法连接"
+			continue
+		}
+		list[i].Seq = info["seq"]
+
+		if info["role"] == "replica" {
+			if info["linked"] != "true" {
+				list[i].Lag = "未同步"
+				continue
+			}
+			list[i].Lag = fmt.Sprintf("%s条 / %sms", info["lag"], info["last_contact"])
+			continue
+		}
+
+		seq, _ := strconv.ParseUint(info["seq"], 10, 64)
+		var lags []string
+		for k, v := range info {
+			if !strings.HasPrefix(k, "replica:") {
+				continue
+			}
+			ack, _ := strconv.ParseUint(v, 10, 64)
+			lag := uint64(0)
+			if seq > ack {
+				lag = seq - ack
+			}
+			lags = append(lags, fmt.Sprintf("%s: %d条", strings.TrimPrefix(k, "replica:"), lag))
+		}
+		sort.Strings(lags)
+		list[i].Lag = strings.Join(lags, ", ")
+	}
+}
+
+//updateSlotReplicas 更新主节点上所有插槽的从节点列表.
+func (admin *Admin) updateSlotReplicas(master string) error {
+	cacheServer, err := admin.getCacheServerList()
+	if err != nil {
+		return err
+	}
+	replicas := base.ReplicasOf(cacheServer, master)
+
+	slotList, err := admin.getSlotList()
+	if err != nil {
+		return err
+	}
+
+	for _, slot := range slotList {
+		if slot.IP != master {
+			continue
+		}
+
+		slot.Replicas = replicas
+		if err := admin.Registry.PutSlot(slot); err != nil {
+			log.Printf("err:%+v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -15,5 +15,7 @@ func (admin *Admin) newWeb() {
 	r.GET("/admin/migrate", admin.MigrateHandle)
 	r.POST("/admin/migrate", admin.MigrateHandle)
 	r.POST("/admin/startmig", admin.StartMigrateHandle)
+	r.GET("/admin/proxy", admin.ProxyHandle)
+	r.GET("/discovery/proxy", admin.DiscoveryHandle)
 	r.Run(admin.Addr)
 }
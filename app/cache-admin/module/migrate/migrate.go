@@ -1,20 +1,33 @@
 package migrate
 
 import (
+	"errors"
 	"log"
+	"net"
+	"strconv"
 	"time"
 
+	"github.com/houzhongjian/bigcache/app/cache-admin/model"
 	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
 )
 
 type Migrate struct {
+	Registry registry.Registry
+	Model    *model.Model
 }
 
-//List 迁移数据的列表
-var List = make(chan base.Slot, 1024)
+//List 迁移任务的列表
+var List = make(chan model.Task, 1024)
 
-func New() *Migrate {
-	return &Migrate{}
+func New(reg registry.Registry) *Migrate {
+	return &Migrate{
+		Registry: reg,
+		Model:    model.New(),
+	}
 }
 
 //Start 开启迁移.
@@ -26,14 +39,92 @@ func (m *Migrate) Start() {
 func (m *Migrate) migrate() {
 	for {
 		select {
-		case slot := <-List:
-			m.handler(slot)
+		case task := <-List:
+			m.handler(task)
 		}
 	}
 }
 
-func (m *Migrate) handler(slot base.Slot) {
-	log.Println("开始迁移:", slot.ID)
-	time.Sleep(time.Second * 5)
-	log.Println(slot.ID, "迁移完成")
+func (m *Migrate) handler(task model.Task) {
+	log.Println("开始迁移:", task.SlotID)
+
+	//通知原节点将插槽数据推送到目标节点.
+	total, err := m.push(task)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		m.fail(task)
+		return
+	}
+
+	//数据迁移完成，插槽恢复为正常状态并指向新节点.
+	slot := base.Slot{
+		ID:       task.SlotID,
+		Types:    base.SLOT_TYPE_NORMAL,
+		IP:       task.TargetIP,
+		Replicas: m.replicasOf(task.TargetIP),
+	}
+	if err := m.Registry.PutSlot(slot); err != nil {
+		log.Printf("err:%+v\n", err)
+		m.fail(task)
+		return
+	}
+
+	//更改任务状态.
+	if err := m.Model.FinishTaskById(task.ID, 2); err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+
+	log.Println(task.SlotID, "迁移完成, 共迁移", total, "条数据")
+}
+
+//fail 任务执行失败.
+//已经推送的数据从原节点删除,迁移过程中的新数据也写入了目标节点,因此插槽保持迁移状态,proxy继续按迁移状态读写.
+//失败的任务可以重新开始,已经存在于目标节点的数据不会被覆盖.
+func (m *Migrate) fail(task model.Task) {
+	log.Println(task.SlotID, "迁移失败, 可以重新开始任务")
+	if err := m.Model.FinishTaskById(task.ID, 3); err != nil {
+		log.Printf("err:%+v\n", err)
+	}
+}
+
+//push 通知原节点推送插槽数据，返回迁移的数据条数.
+func (m *Migrate) push(task model.Task) (total int, err error) {
+	conn, err := net.DialTimeout("tcp4", task.MigrateIP, time.Second*5)
+	if err != nil {
+		return total, err
+	}
+	defer conn.Close()
+
+	h, err := packet.Auth(conn, conf.GetString("auth_secret"), packet.CAP_CHECKSUM)
+	if err != nil {
+		return total, err
+	}
+
+	b := packet.EncodeArgs(strconv.Itoa(task.SlotID), task.TargetIP)
+	buf := h.NewRequest(0, b, packet.MIGRATE)
+	if _, err := conn.Write(buf); err != nil {
+		return total, err
+	}
+
+	pkt, err := h.ParseResponse(conn)
+	if err != nil {
+		return total, err
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		return total, errors.New(pkt.Msg)
+	}
+
+	return strconv.Atoi(pkt.Msg)
+}
+
+//replicasOf 获取节点的所有从节点ip地址.
+func (m *Migrate) replicasOf(ip string) []string {
+	list, err := m.Registry.Nodes()
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return nil
+	}
+	return base.ReplicasOf(list, ip)
 }
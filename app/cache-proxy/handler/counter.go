@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//incr 处理 INCR、DECR 命令.
+func (r *Redis) incr(srv *Pool, args [][]byte, delta int64) {
+	r.intRequest(srv, packet.INCR, string(args[0]), strconv.FormatInt(delta, 10))
+}
+
+//incrby 处理 INCRBY、DECRBY 命令,sign为-1时表示减少.
+func (r *Redis) incrby(srv *Pool, args [][]byte, sign int64) {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		r.error(errcode.MSG_NOT_INTEGER)
+		return
+	}
+
+	if sign < 0 {
+		if delta == math.MinInt64 {
+			r.error("ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	r.intRequest(srv, packet.INCR, string(args[0]), strconv.FormatInt(delta, 10))
+}
+
+//incrbyfloat 处理 INCRBYFLOAT 命令.
+func (r *Redis) incrbyfloat(srv *Pool, args [][]byte) {
+	if _, err := strconv.ParseFloat(string(args[1]), 64); err != nil {
+		r.error(errcode.MSG_NOT_FLOAT)
+		return
+	}
+
+	pkt, err := r.request(srv, packet.INCR_FLOAT, string(args[0]), string(args[1]))
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		r.error(pkt.Msg)
+		return
+	}
+	r.write(pkt.Msg, len(pkt.Msg))
+}
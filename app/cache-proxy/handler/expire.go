@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//expire 处理 EXPIRE、PEXPIRE、EXPIREAT、PEXPIREAT 命令,unit为时间单位对应的毫秒数.
+func (r *Redis) expire(srv *Pool, args [][]byte, num packet.BigcacheProtocol, unit int64) {
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		r.error(errcode.MSG_NOT_INTEGER)
+		return
+	}
+
+	r.intRequest(srv, num, string(args[0]), strconv.FormatInt(n*unit, 10))
+}
+
+//ttl 处理 TTL、PTTL 命令,unit为时间单位对应的毫秒数.
+func (r *Redis) ttl(srv *Pool, args [][]byte, unit int64) {
+	pkt, err := r.request(srv, packet.TTL, string(args[0]))
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		r.error(pkt.Msg)
+		return
+	}
+
+	ttl, err := strconv.ParseInt(pkt.Msg, 10, 64)
+	if err != nil {
+		r.error(err.Error())
+		return
+	}
+
+	//-1、-2 表示没有过期时间和key不存在,直接返回.
+	if ttl > 0 && unit > 1 {
+		ttl = (ttl + unit/2) / unit
+	}
+	r.int(int(ttl))
+}
+
+//persist 处理 PERSIST 命令.
+func (r *Redis) persist(srv *Pool, args [][]byte) {
+	r.intRequest(srv, packet.PERSIST, string(args[0]))
+}
+
+//intRequest 发送请求,并将cache server 返回的数字作为整数回复.
+func (r *Redis) intRequest(srv *Pool, num packet.BigcacheProtocol, args ...string) {
+	pkt, err := r.request(srv, num, args...)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		r.error(pkt.Msg)
+		return
+	}
+
+	n, err := strconv.Atoi(pkt.Msg)
+	if err != nil {
+		r.error(err.Error())
+		return
+	}
+	r.int(n)
+}
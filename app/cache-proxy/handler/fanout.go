@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//fanoutCommands 可以包含多个key的命令及每个key占用的参数个数.
+//这些命令按key所在的cache server拆分,并发发送之后按原来的顺序合并结果.
+var fanoutCommands = map[string]int{
+	"MGET": 1,
+	"MSET": 2,
+	"DEL":  1,
+}
+
+//fanoutBatch 发送到同一个cache server的子请求.
+type fanoutBatch struct {
+	srv     *Pool
+	oldSrv  *Pool    //插槽处于迁移状态时key所在的旧节点.
+	migrate []string //需要先迁移到新节点的key.
+	index   []int    //key在原请求中的位置.
+	args    []string
+	epoch   uint64 //子请求中所有插槽最大的epoch.
+	moved   bool   //cache server返回了MOVED,需要使用刷新之后的路由表重新拆分.
+	pkt     packet.Response
+	err     error
+}
+
+//fanout 处理 MGET、MSET、DEL 命令.
+//MSET在多个cache server之间不是原子操作,部分失败时返回错误.
+//cache server返回MOVED时只重新发送对应的子请求,已经成功的子请求不会重复执行.
+func (r *Redis) fanout(proto RedisProto) {
+	step := fanoutCommands[proto.Command]
+	if len(proto.Args) == 0 || len(proto.Args)%step != 0 {
+		r.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(proto.Command)))
+		return
+	}
+
+	num := packet.MREAD
+	switch proto.Command {
+	case "MSET":
+		num = packet.MWRITE
+	case "DEL":
+		num = packet.DELETE
+	}
+
+	positions := make([]int, 0, len(proto.Args)/step)
+	for i := 0; i < len(proto.Args); i += step {
+		positions = append(positions, i)
+	}
+
+	order := []*fanoutBatch{}
+	for retry := 0; len(positions) > 0; retry++ {
+		batches, err := r.fanoutBatches(proto, step, positions)
+		if err != nil {
+			r.error(err.Error())
+			return
+		}
+		r.sendBatches(batches, num)
+
+		positions = positions[:0]
+		for _, batch := range batches {
+			if batch.moved && retry < MAX_REDIRECTS {
+				for _, index := range batch.index {
+					positions = append(positions, index*step)
+				}
+				continue
+			}
+			order = append(order, batch)
+		}
+	}
+
+	for _, batch := range order {
+		if batch.err != nil {
+			r.error(batch.err.Error())
+			return
+		}
+	}
+
+	switch proto.Command {
+	case "MGET":
+		r.mgetReply(order, len(proto.Args))
+	case "MSET":
+		r.connection()
+	case "DEL":
+		total := 0
+		for _, batch := range order {
+			n, err := strconv.Atoi(batch.pkt.Msg)
+			if err != nil {
+				r.error(err.Error())
+				return
+			}
+			total += n
+		}
+		r.int(total)
+	}
+}
+
+//fanoutBatches 将参数中positions位置的key按所在的cache server拆分.
+func (r *Redis) fanoutBatches(proto RedisProto, step int, positions []int) ([]*fanoutBatch, error) {
+	batches := map[string]*fanoutBatch{}
+	order := []*fanoutBatch{}
+	for _, i := range positions {
+		key := string(proto.Args[i])
+		slot, err := r.proxy.slotOf(key)
+		if err != nil {
+			return nil, err
+		}
+
+		//插槽处于迁移状态时,先将key迁移到新节点,之后只操作新节点.
+		name := slot.IP
+		if slot.Types == base.SLOT_TYPE_MIGRATE {
+			name = slot.IP + "->" + slot.NewIP
+		}
+
+		batch, ok := batches[name]
+		if !ok {
+			batch = &fanoutBatch{srv: r.proxy.getCacheServer(slot.IP)}
+			if slot.Types == base.SLOT_TYPE_MIGRATE {
+				batch.oldSrv = batch.srv
+				batch.srv = r.proxy.getCacheServer(slot.NewIP)
+			}
+			batches[name] = batch
+			order = append(order, batch)
+		}
+
+		if slot.Types == base.SLOT_TYPE_MIGRATE {
+			batch.migrate = append(batch.migrate, key)
+		}
+		if slot.Epoch > batch.epoch {
+			batch.epoch = slot.Epoch
+		}
+		batch.index = append(batch.index, i/step)
+		batch.args = append(batch.args, stringArgs(proto.Args[i:i+step])...)
+	}
+	return order, nil
+}
+
+//sendBatches 并发发送子请求,每个子请求使用各自的epoch.
+func (r *Redis) sendBatches(order []*fanoutBatch, num packet.BigcacheProtocol) {
+	var wg sync.WaitGroup
+	for _, batch := range order {
+		wg.Add(1)
+		go func(batch *fanoutBatch) {
+			defer wg.Done()
+
+			sub := *r
+			sub.epoch = batch.epoch
+			sub.moved = false
+			defer func() { batch.moved = sub.moved }()
+
+			for _, key := range batch.migrate {
+				if batch.err = sub.migrateKey(batch.oldSrv, batch.srv, key); batch.err != nil {
+					return
+				}
+			}
+
+			batch.pkt, batch.err = sub.request(batch.srv, num, batch.args...)
+			if batch.err == nil && batch.pkt.Err != errcode.NO_ERROR {
+				batch.err = errors.New(batch.pkt.Msg)
+			}
+		}(batch)
+	}
+	wg.Wait()
+}
+
+//mgetReply 按key原来的顺序合并各个cache server返回的结果.
+func (r *Redis) mgetReply(order []*fanoutBatch, n int) {
+	list := make([]*string, n)
+	for _, batch := range order {
+		values, err := packet.DecodeArray([]byte(batch.pkt.Msg))
+		if err != nil {
+			r.error(err.Error())
+			return
+		}
+		if len(values) != len(batch.index) {
+			r.error("cache server 返回数据异常")
+			return
+		}
+
+		for i, index := range batch.index {
+			list[index] = values[i]
+		}
+	}
+
+	r.array(list)
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//TestFanoutOrder 按cache server拆分子请求,合并结果时恢复key原来的顺序.
+func TestFanoutOrder(t *testing.T) {
+	p := newTestProxy()
+	p.replaceSlots([]base.Slot{
+		{ID: int(utils.Slot("{1}")), IP: "a", Epoch: 1},
+		{ID: int(utils.Slot("{2}")), IP: "b", Epoch: 3},
+	})
+	r := &Redis{proxy: p}
+
+	proto := RedisProto{Command: "MSET"}
+	for _, arg := range []string{"{1}x", "1", "{2}y", "2", "{1}z", "3"} {
+		proto.Args = append(proto.Args, []byte(arg))
+	}
+	batches, err := r.fanoutBatches(proto, 2, []int{0, 2, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("子请求个数: %d", len(batches))
+	}
+	if !reflect.DeepEqual(batches[0].index, []int{0, 2}) || !reflect.DeepEqual(batches[0].args, []string{"{1}x", "1", "{1}z", "3"}) {
+		t.Fatalf("第一个子请求: %+v", batches[0])
+	}
+	if !reflect.DeepEqual(batches[1].index, []int{1}) || batches[1].epoch != 3 {
+		t.Fatalf("第二个子请求: %+v", batches[1])
+	}
+
+	//MGET的结果按原来的顺序合并,不存在的key返回nil.
+	x, z := "1", "3"
+	batches[0].pkt.Msg = string(packet.EncodeArray([]*string{&x, &z}))
+	batches[1].pkt.Msg = string(packet.EncodeArray([]*string{nil}))
+	var buf bytes.Buffer
+	r.writer = &buf
+	r.mgetReply(batches, 3)
+	if buf.String() != "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n3\r\n" {
+		t.Fatalf("MGET: %q", buf.String())
+	}
+}
+
+//TestFanoutUnassigned 任意一个key的插槽未分配时返回错误.
+func TestFanoutUnassigned(t *testing.T) {
+	r := &Redis{proxy: newTestProxy()}
+	proto := RedisProto{Command: "MGET", Args: [][]byte{[]byte("k")}}
+	if _, err := r.fanoutBatches(proto, 1, []int{0}); err == nil {
+		t.Fatal("插槽未分配时没有返回错误")
+	}
+}
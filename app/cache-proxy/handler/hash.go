@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//hset 处理 HSET 命令,参数为key、field、value、field、value...
+func (r *Redis) hset(srv *Pool, args [][]byte) {
+	if len(args)%2 == 0 {
+		r.error("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+
+	r.intRequest(srv, packet.HSET, stringArgs(args)...)
+}
+
+//hget 处理 HGET 命令.
+func (r *Redis) hget(srv *Pool, args [][]byte) {
+	pkt, err := r.request(srv, packet.HGET, string(args[0]), string(args[1]))
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		if pkt.Err == errcode.NOT_FOUND {
+			r.write(pkt.Msg, -1)
+			return
+		}
+		r.error(pkt.Msg)
+		return
+	}
+	r.write(pkt.Msg, len(pkt.Msg))
+}
+
+//hincrby 处理 HINCRBY 命令.
+func (r *Redis) hincrby(srv *Pool, args [][]byte) {
+	if _, err := strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+		r.error(errcode.MSG_NOT_INTEGER)
+		return
+	}
+
+	r.intRequest(srv, packet.HINCRBY, string(args[0]), string(args[1]), string(args[2]))
+}
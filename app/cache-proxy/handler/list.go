@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//pop 处理 LPOP、RPOP 命令.
+//没有count参数时返回单个元素,有count参数时返回数组,key不存在时返回nil.
+func (r *Redis) pop(srv *Pool, args [][]byte, num packet.BigcacheProtocol) {
+	if len(args) > 2 {
+		r.error("ERR syntax error")
+		return
+	}
+
+	count := 1
+	if len(args) > 1 {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n < 0 {
+			r.error("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+
+	pkt, err := r.request(srv, num, string(args[0]), strconv.Itoa(count))
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		r.error(pkt.Msg)
+		return
+	}
+
+	list, err := packet.DecodeArray([]byte(pkt.Msg))
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if len(args) > 1 {
+		if len(list) == 0 && count > 0 {
+			r.writer.Write([]byte("*-1\r\n"))
+			return
+		}
+		r.array(list)
+		return
+	}
+
+	if len(list) == 0 {
+		r.write("", -1)
+		return
+	}
+	r.write(*list[0], len(*list[0]))
+}
+
+//lrange 处理 LRANGE 命令.
+func (r *Redis) lrange(srv *Pool, args [][]byte) {
+	if !r.checkIndex(args[1:]) {
+		return
+	}
+
+	r.listRequest(srv, packet.LRANGE, stringArgs(args)...)
+}
+
+//ltrim 处理 LTRIM 命令.
+func (r *Redis) ltrim(srv *Pool, args [][]byte) {
+	if !r.checkIndex(args[1:]) {
+		return
+	}
+
+	pkt, err := r.request(srv, packet.LTRIM, stringArgs(args)...)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		r.error(pkt.Msg)
+		return
+	}
+	r.connection()
+}
+
+//checkIndex 检查start、stop参数是否为整数.
+func (r *Redis) checkIndex(args [][]byte) bool {
+	for _, arg := range args[:2] {
+		if _, err := strconv.Atoi(string(arg)); err != nil {
+			r.error(errcode.MSG_NOT_INTEGER)
+			return false
+		}
+	}
+	return true
+}
@@ -1,13 +1,27 @@
 package handler
 
-func (r *Redis) migrateSet() {
+import (
+	"errors"
 
-}
-
-func (r *Redis) migrateGet() {
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
 
-}
+//migrateKey 插槽处于迁移状态时,通知旧节点将key迁移到新节点.
+//读取、写入新节点和删除都由旧节点在key的锁中完成,不会与其他proxy或插槽迁移的写入交错.
+//迁移之后key只存在于新节点,命令只需要在新节点执行.
+func (r *Redis) migrateKey(srv, newSrv *Pool, key string) error {
+	if newSrv == nil {
+		return errors.New("cache server 未连接")
+	}
 
-func (r *Redis) migrateDel() {
+	pkt, err := r.request(srv, packet.MIGRATE_KEY, key, newSrv.Addr)
+	if err != nil {
+		return err
+	}
+	if pkt.Err != errcode.NO_ERROR {
+		return errors.New(pkt.Msg)
+	}
 
+	return nil
 }
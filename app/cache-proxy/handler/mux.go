@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+var (
+	ErrMuxClosed  = errors.New("连接已关闭")
+	ErrMuxTimeout = errors.New("请求超时")
+)
+
+//MuxConn 多路复用的cache server 连接.
+//同一个连接上可以同时发送多个请求,cache server 按请求ID返回,由读取协程分发给等待的调用方.
+type MuxConn struct {
+	conn    net.Conn
+	timeout time.Duration //单次请求的超时时间.
+	lock    *sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan packet.Response //等待返回的请求.
+	frames  chan []byte                     //等待发送的请求.
+	closed  chan struct{}
+	serial  chan struct{}    //不为nil时请求依次发送,等待上一个请求返回.
+	h       packet.Handshake //握手协商的结果,决定数据包的格式以及是否附加路由表的epoch.
+	err     error
+}
+
+//DialMux 建立多路复用连接.
+func DialMux(addr string, opts PoolOptions) (*MuxConn, error) {
+	conn, err := net.DialTimeout("tcp4", addr, opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	//握手完成之后才开始收发请求.
+	conn.SetDeadline(time.Now().Add(opts.DialTimeout))
+	h, err := packet.Auth(conn, opts.Secret, packet.CAPABILITIES)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &MuxConn{
+		conn:    conn,
+		timeout: opts.IOTimeout,
+		lock:    &sync.Mutex{},
+		pending: make(map[uint32]chan packet.Response),
+		frames:  make(chan []byte, 1024),
+		closed:  make(chan struct{}),
+		h:       h,
+	}
+	//cache server不支持多路复用时,同一时间只发送一个请求.
+	if !h.Has(packet.CAP_MULTIPLEX) {
+		c.serial = make(chan struct{}, 1)
+	}
+
+	go c.readLoop()
+	go c.writeLoop()
+	return c, nil
+}
+
+//Do 发送请求并等待返回结果,epoch为路由表中插槽的epoch,cache server不支持时不发送.
+func (c *MuxConn) Do(num packet.BigcacheProtocol, epoch uint64, content []byte) (pkt packet.Response, err error) {
+	if c.h.Has(packet.CAP_EPOCH) {
+		content = packet.WithEpoch(epoch, content)
+	}
+	if err := packet.CheckFrameSize(len(content)); err != nil {
+		return pkt, err
+	}
+
+	if c.serial != nil {
+		select {
+		case c.serial <- struct{}{}:
+			defer func() { <-c.serial }()
+		case <-c.closed:
+			return pkt, c.Err()
+		}
+	}
+
+	ch := make(chan packet.Response, 1)
+
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return pkt, c.err
+	}
+	//0表示不使用多路复用,跳过.
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	id := c.nextID
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case c.frames <- c.h.NewRequest(id, content, num):
+	case <-c.closed:
+		c.remove(id)
+		return pkt, c.Err()
+	case <-timer.C:
+		c.remove(id)
+		return pkt, ErrMuxTimeout
+	}
+
+	select {
+	case pkt = <-ch:
+		return pkt, nil
+	case <-c.closed:
+		//连接关闭之前可能已经收到返回结果.
+		select {
+		case pkt = <-ch:
+			return pkt, nil
+		default:
+		}
+		c.remove(id)
+		return pkt, c.Err()
+	case <-timer.C:
+		c.remove(id)
+		return pkt, ErrMuxTimeout
+	}
+}
+
+//remove 移除等待返回的请求,超时之后到达的返回结果会被丢弃.
+func (c *MuxConn) remove(id uint32) {
+	c.lock.Lock()
+	delete(c.pending, id)
+	c.lock.Unlock()
+}
+
+//readLoop 读取返回结果并按请求ID分发.
+func (c *MuxConn) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		pkt, err := c.h.ParseResponse(reader)
+		if err != nil {
+			c.closeWith(err)
+			return
+		}
+
+		c.lock.Lock()
+		ch, ok := c.pending[pkt.ID]
+		delete(c.pending, pkt.ID)
+		c.lock.Unlock()
+
+		if ok {
+			ch <- pkt
+		}
+	}
+}
+
+//writeLoop 发送请求,同时到达的多个请求合并写入.
+func (c *MuxConn) writeLoop() {
+	w := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case buf := <-c.frames:
+			c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+			if _, err := w.Write(buf); err != nil {
+				c.closeWith(err)
+				return
+			}
+
+			if len(c.frames) > 0 {
+				continue
+			}
+			if err := w.Flush(); err != nil {
+				c.closeWith(err)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+//closeWith 关闭连接,等待中的请求返回err.
+func (c *MuxConn) closeWith(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.closed)
+	c.conn.Close()
+}
+
+//Close 关闭连接.
+func (c *MuxConn) Close() {
+	c.closeWith(ErrMuxClosed)
+}
+
+//Closed 连接是否已经关闭.
+func (c *MuxConn) Closed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+//Err 连接关闭的原因.
+func (c *MuxConn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//DEFAULT_PIPELINE_SIZE 单个客户端连接同时处理的最大命令数.
+const DEFAULT_PIPELINE_SIZE = 128
+
+//command 流水线中的一个命令.
+type command struct {
+	proto RedisProto
+	reply []byte
+	done  chan struct{} //命令执行完成后关闭.
+}
+
+//pipeline 客户端连接的命令流水线.
+//命令并发发送到cache server,同一个插槽的命令按请求顺序执行,回复按请求顺序返回.
+//没有key或者涉及多个插槽的命令作为屏障,等待之前的命令全部完成后执行,之后的命令等待它完成.
+type pipeline struct {
+	redis   *Redis
+	queue   chan *command         //等待回复的命令,按请求顺序排列.
+	slots   map[int]chan struct{} //每个插槽最后一个命令的完成通知.
+	barrier chan struct{}         //最后一个屏障命令的完成通知.
+}
+
+//newPipeline 创建流水线,size为同时处理的最大命令数.
+func (r *Redis) newPipeline(size int) *pipeline {
+	if size <= 0 {
+		size = DEFAULT_PIPELINE_SIZE
+	}
+
+	return &pipeline{
+		redis: r,
+		queue: make(chan *command, size),
+		slots: make(map[int]chan struct{}),
+	}
+}
+
+//dispatch 将命令加入流水线,流水线已满时阻塞.
+func (pipe *pipeline) dispatch(proto RedisProto) {
+	cmd := &command{
+		proto: proto,
+		done:  make(chan struct{}),
+	}
+
+	wait := []chan struct{}{}
+	if pipe.barrier != nil {
+		wait = append(wait, pipe.barrier)
+	}
+
+	if slot, ok := commandSlot(proto); ok {
+		if prev, ok := pipe.slots[slot]; ok {
+			wait = append(wait, prev)
+		}
+		pipe.prune()
+		pipe.slots[slot] = cmd.done
+	} else {
+		for _, prev := range pipe.slots {
+			wait = append(wait, prev)
+		}
+		pipe.slots = make(map[int]chan struct{})
+		pipe.barrier = cmd.done
+	}
+
+	pipe.queue <- cmd
+	go pipe.exec(cmd, wait)
+}
+
+//prune 插槽较多时清除已经完成的命令.
+func (pipe *pipeline) prune() {
+	if len(pipe.slots) < 1024 {
+		return
+	}
+
+	for slot, done := range pipe.slots {
+		select {
+		case <-done:
+			delete(pipe.slots, slot)
+		default:
+		}
+	}
+}
+
+//exec 等待依赖的命令完成后执行命令,回复写入命令自己的缓冲区.
+//cache server返回MOVED时丢弃回复,使用刷新之后的路由表重新执行.
+func (pipe *pipeline) exec(cmd *command, wait []chan struct{}) {
+	for _, done := range wait {
+		<-done
+	}
+
+	var buf bytes.Buffer
+	r := *pipe.redis
+	for i := 0; ; i++ {
+		buf.Reset()
+		r.writer = &buf
+		r.moved = false
+		r.exec(cmd.proto)
+		if !r.moved || i >= MAX_REDIRECTS {
+			break
+		}
+	}
+
+	cmd.reply = buf.Bytes()
+	close(cmd.done)
+}
+
+//reply 按请求顺序将回复写入客户端连接,流水线关闭后关闭连接.
+func (pipe *pipeline) reply(conn net.Conn) {
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	var err error
+	for cmd := range pipe.queue {
+		select {
+		case <-cmd.done:
+		default:
+			//下一个命令还未完成,先发送已经完成的回复.
+			if err == nil {
+				err = w.Flush()
+			}
+			<-cmd.done
+		}
+
+		//连接异常时继续等待剩余的命令完成,避免阻塞流水线.
+		if err != nil {
+			continue
+		}
+		if _, err = w.Write(cmd.reply); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+		if len(pipe.queue) == 0 {
+			err = w.Flush()
+		}
+	}
+
+	if err == nil {
+		w.Flush()
+	}
+}
+
+//commandSlot 获取命令操作的插槽,没有key的命令以及可能涉及多个插槽的命令返回false.
+func commandSlot(proto RedisProto) (int, bool) {
+	if _, ok := fanoutCommands[proto.Command]; ok {
+		return commandFanoutSlot(proto)
+	}
+
+	n, ok := keyCommands[proto.Command]
+	if !ok || len(proto.Args) < n || len(proto.Args) == 0 {
+		return 0, false
+	}
+
+	//多个key的命令在插槽不同时会返回CROSSSLOT错误,这里只使用第一个key.
+	return int(utils.Slot(string(proto.Args[0]))), true
+}
+
+//commandFanoutSlot 多个key的命令所有key在同一个插槽时返回该插槽.
+func commandFanoutSlot(proto RedisProto) (int, bool) {
+	step := fanoutCommands[proto.Command]
+	if len(proto.Args) == 0 || len(proto.Args)%step != 0 {
+		return 0, false
+	}
+
+	slot := utils.Slot(string(proto.Args[0]))
+	for i := step; i < len(proto.Args); i += step {
+		if utils.Slot(string(proto.Args[i])) != slot {
+			return 0, false
+		}
+	}
+	return int(slot), true
+}
+
+//exec 执行一个命令.
+func (r *Redis) exec(proto RedisProto) {
+	//多个key的命令按插槽拆分后发送.
+	if _, ok := fanoutCommands[proto.Command]; ok {
+		r.fanout(proto)
+		return
+	}
+
+	//根据key获取插槽信息.
+	slot, err := r.proxy.getSlot(proto)
+	if err != nil {
+		r.error(err.Error())
+		return
+	}
+
+	r.epoch = slot.Epoch
+	r.service(proto, slot)
+}
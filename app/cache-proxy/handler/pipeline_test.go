@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+//TestPipelineReplyOrder 后发送的命令先完成时,回复仍然按请求顺序返回.
+func TestPipelineReplyOrder(t *testing.T) {
+	pipe := &pipeline{queue: make(chan *command, 3)}
+	server, client := net.Pipe()
+	defer client.Close()
+	go pipe.reply(server)
+
+	cmds := make([]*command, 3)
+	for i := range cmds {
+		cmds[i] = &command{reply: []byte{'1' + byte(i)}, done: make(chan struct{})}
+		pipe.queue <- cmds[i]
+	}
+	go func() {
+		for i := len(cmds) - 1; i >= 0; i-- {
+			time.Sleep(5 * time.Millisecond)
+			close(cmds[i].done)
+		}
+		close(pipe.queue)
+	}()
+
+	buf, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "123" {
+		t.Fatalf("回复顺序: %q", buf)
+	}
+}
+
+//TestCommandSlot 单个插槽的命令按插槽排队,没有key或者跨插槽的命令作为屏障.
+func TestCommandSlot(t *testing.T) {
+	proto := func(cmd string, args ...string) RedisProto {
+		p := RedisProto{Command: cmd}
+		for _, arg := range args {
+			p.Args = append(p.Args, []byte(arg))
+		}
+		return p
+	}
+
+	if _, ok := commandSlot(proto("GET", "k")); !ok {
+		t.Fatal("GET没有插槽")
+	}
+	if _, ok := commandSlot(proto("MGET", "{u}a", "{u}b")); !ok {
+		t.Fatal("同一个插槽的MGET没有插槽")
+	}
+	if _, ok := commandSlot(proto("MSET", "{u}a", "1", "{v}b", "2")); ok {
+		t.Fatal("跨插槽的MSET应该作为屏障")
+	}
+	if _, ok := commandSlot(proto("PING")); ok {
+		t.Fatal("PING应该作为屏障")
+	}
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+var (
+	ErrPoolClosed = errors.New("连接池已关闭")
+)
+
+//PoolOptions 连接池配置.
+type PoolOptions struct {
+	Size           int           //连接数,每个连接可以同时发送多个请求.
+	DialTimeout    time.Duration //建立连接超时时间.
+	IOTimeout      time.Duration //单次请求的超时时间.
+	HealthInterval time.Duration //连接健康检查间隔.
+	Secret         string        //连接授权密钥.
+}
+
+//Pool cache server 连接池.
+//连接使用请求ID多路复用,请求轮流使用各个连接,连接在使用时才会建立,断开之后重新建立.
+type Pool struct {
+	Addr   string
+	opts   PoolOptions
+	locks  []sync.Mutex
+	conns  []*MuxConn
+	next   uint32
+	check  chan struct{} //立即执行健康检查.
+	closed chan struct{}
+}
+
+//NewPoolOptions 从配置文件中读取连接池配置,时间单位为毫秒.
+func NewPoolOptions() PoolOptions {
+	opts := PoolOptions{
+		Size:           conf.GetInt("pool_size"),
+		DialTimeout:    time.Duration(conf.GetInt("pool_dial_timeout")) * time.Millisecond,
+		IOTimeout:      time.Duration(conf.GetInt("pool_io_timeout")) * time.Millisecond,
+		HealthInterval: time.Duration(conf.GetInt("pool_health_interval")) * time.Millisecond,
+		Secret:         conf.GetString("auth_secret"),
+	}
+
+	if opts.Size < 1 {
+		opts.Size = 4
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = time.Second * 3
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = time.Second * 3
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = time.Second * 30
+	}
+
+	return opts
+}
+
+//NewPool 创建连接池,连接在使用时才会建立.
+func NewPool(addr string, opts PoolOptions) *Pool {
+	pool := &Pool{
+		Addr:   addr,
+		opts:   opts,
+		locks:  make([]sync.Mutex, opts.Size),
+		conns:  make([]*MuxConn, opts.Size),
+		check:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+
+	go pool.healthCheck()
+	return pool
+}
+
+//Get 轮流获取一个连接,连接不存在或者已经断开时建立新连接.
+func (pool *Pool) Get() (*MuxConn, error) {
+	i := int(atomic.AddUint32(&pool.next, 1) % uint32(len(pool.conns)))
+	return pool.get(i)
+}
+
+func (pool *Pool) get(i int) (*MuxConn, error) {
+	pool.locks[i].Lock()
+	defer pool.locks[i].Unlock()
+
+	select {
+	case <-pool.closed:
+		return nil, ErrPoolClosed
+	default:
+	}
+
+	conn := pool.conns[i]
+	if conn != nil && !conn.Closed() {
+		return conn, nil
+	}
+
+	conn, err := DialMux(pool.Addr, pool.opts)
+	if err != nil {
+		return nil, err
+	}
+	pool.conns[i] = conn
+	return conn, nil
+}
+
+//Do 发送请求并读取返回结果,请求超时时检查连接是否可用.
+func (pool *Pool) Do(num packet.BigcacheProtocol, epoch uint64, content []byte) (pkt packet.Response, err error) {
+	conn, err := pool.Get()
+	if err != nil {
+		return pkt, err
+	}
+
+	pkt, err = conn.Do(num, epoch, content)
+	if err == ErrMuxTimeout {
+		select {
+		case pool.check <- struct{}{}:
+		default:
+		}
+	}
+	return pkt, err
+}
+
+//ping 检测连接是否可用.
+func (pool *Pool) ping(conn *MuxConn) error {
+	pkt, err := conn.Do(packet.PING, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		return errors.New(pkt.Msg)
+	}
+
+	return nil
+}
+
+//healthCheck 定时检查连接,关闭已经失效的连接.
+func (pool *Pool) healthCheck() {
+	ticker := time.NewTicker(pool.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.checkConns()
+		case <-pool.check:
+			pool.checkConns()
+		case <-pool.closed:
+			return
+		}
+	}
+}
+
+//checkConns 检查所有已经建立的连接,失效的连接在下次使用时重新建立.
+func (pool *Pool) checkConns() {
+	for i := range pool.conns {
+		pool.locks[i].Lock()
+		conn := pool.conns[i]
+		pool.locks[i].Unlock()
+
+		if conn == nil || conn.Closed() {
+			continue
+		}
+
+		if err := pool.ping(conn); err != nil {
+			log.Println("cache server ip:", pool.Addr, "连接失效:", err)
+			conn.Close()
+		}
+	}
+}
+
+//Close 关闭连接池及所有连接,等待中的请求返回错误.
+func (pool *Pool) Close() {
+	for i := range pool.conns {
+		pool.locks[i].Lock()
+	}
+	defer func() {
+		for i := range pool.conns {
+			pool.locks[i].Unlock()
+		}
+	}()
+
+	select {
+	case <-pool.closed:
+		return
+	default:
+	}
+	close(pool.closed)
+
+	for _, conn := range pool.conns {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
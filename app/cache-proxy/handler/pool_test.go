@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//echoServer 完成握手之后原样返回请求内容的cache server,后收到的请求可能先返回.
+func echoServer(t *testing.T, secret string) net.Listener {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go echo(conn, secret)
+		}
+	}()
+	return l
+}
+
+//echo 处理一个连接,返回数据时加锁.
+func echo(conn net.Conn, secret string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	pkt, err := packet.ParseRequest(reader)
+	if err != nil {
+		return
+	}
+	h, msg, err := packet.Accept(pkt.Body, secret)
+	if err != nil {
+		conn.Write(packet.NewResponse(err.Error(), errcode.AUTH_FAILED))
+		return
+	}
+	conn.Write(packet.NewResponse(msg, errcode.NO_ERROR))
+
+	lock := &sync.Mutex{}
+	for {
+		pkt, err := h.ParseRequest(reader)
+		if err != nil {
+			return
+		}
+		if h.Has(packet.CAP_EPOCH) {
+			if _, pkt.Body, err = packet.SplitEpoch(pkt.Body); err != nil {
+				return
+			}
+		}
+		go func(pkt packet.Request) {
+			time.Sleep(time.Duration(pkt.ID%3) * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			conn.Write(h.NewResponse(pkt.ID, string(pkt.Body), errcode.NO_ERROR))
+		}(pkt)
+	}
+}
+
+//TestPoolMultiplex 同一个连接上并发的请求按请求ID返回给各自的调用方.
+func TestPoolMultiplex(t *testing.T) {
+	l := echoServer(t, "secret")
+	defer l.Close()
+
+	pool := NewPool(l.Addr().String(), PoolOptions{
+		Size:           2,
+		DialTimeout:    time.Second,
+		IOTimeout:      time.Second,
+		HealthInterval: time.Minute,
+		Secret:         "secret",
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := strconv.Itoa(i)
+			pkt, err := pool.Do(packet.READ, 1, []byte(msg))
+			if err != nil || pkt.Msg != msg {
+				t.Errorf("请求%d: %+v %v", i, pkt, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	pool.Close()
+	if _, err := pool.Do(packet.READ, 1, nil); err != ErrPoolClosed {
+		t.Fatalf("关闭之后: %v", err)
+	}
+}
+
+//TestPoolSecret 密钥不一致时建立连接失败.
+func TestPoolSecret(t *testing.T) {
+	l := echoServer(t, "secret")
+	defer l.Close()
+
+	pool := NewPool(l.Addr().String(), PoolOptions{Size: 1, DialTimeout: time.Second, IOTimeout: time.Second, HealthInterval: time.Minute, Secret: "wrong"})
+	defer pool.Close()
+	if _, err := pool.Get(); err == nil {
+		t.Fatal("密钥错误时建立了连接")
+	}
+}
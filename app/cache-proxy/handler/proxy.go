@@ -4,32 +4,67 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 
-	"github.com/houzhongjian/bigcache/lib/etcd"
-
-	"go.etcd.io/etcd/clientv3"
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
 
 	"github.com/houzhongjian/bigcache/lib/conf"
 )
 
 type Proxy struct {
-	Addr        string
-	Ch          chan bool
-	Etcd        *clientv3.Client
-	Lock        *sync.RWMutex
-	CacheServer map[string]net.Conn
+	Addr          string
+	Ch            chan bool
+	Registry      registry.Registry //集群信息的注册中心.
+	Lock          *sync.RWMutex
+	CacheServer   map[string]*Pool  //cache server 连接池.
+	Masters       map[string]string //从节点的主节点,由etcd中的节点信息同步.
+	PoolOptions   PoolOptions
+	SlotLock      *sync.RWMutex
+	SlotTable     map[int]base.Slot //插槽路由表,由etcd中的/slot/同步.
+	PipelineSize  int               //单个客户端连接同时处理的最大命令数.
+	AdvertiseAddr string            //注册到etcd中供客户端连接的地址.
+	HeartbeatTTL  int64             //注册信息租约的过期时间,单位秒.
+	clients       int64             //当前连接的客户端数量.
 }
 
 //NewProxy.
 func NewProxy() Proxy {
 	p := Proxy{
-		Addr:        conf.GetString("addr"),
-		Ch:          make(chan bool),
-		Etcd:        etcd.New(conf.GetString("etcd_addr")),
-		CacheServer: make(map[string]net.Conn),
-		Lock:        &sync.RWMutex{},
+		Addr:          conf.GetString("addr"),
+		Ch:            make(chan bool),
+		CacheServer:   make(map[string]*Pool),
+		Masters:       make(map[string]string),
+		PoolOptions:   NewPoolOptions(),
+		Lock:          &sync.RWMutex{},
+		SlotLock:      &sync.RWMutex{},
+		SlotTable:     make(map[int]base.Slot),
+		PipelineSize:  conf.GetInt("pipeline_size"),
+		AdvertiseAddr: conf.GetString("advertise_addr"),
+		HeartbeatTTL:  int64(conf.GetInt("heartbeat_ttl")),
+	}
+	if len(p.AdvertiseAddr) < 1 {
+		p.AdvertiseAddr = p.Addr
+	}
+	if p.HeartbeatTTL <= 0 {
+		p.HeartbeatTTL = 5
+	}
+	//cache server只接受通过密钥握手的连接.
+	if len(p.PoolOptions.Secret) < 1 {
+		log.Printf("err:%+v\n", packet.ErrNoSecret)
+		os.Exit(1)
 	}
+
+	reg, err := registry.New()
+	if err != nil {
+		panic(err)
+	}
+	p.Registry = reg
+	p.loadSlotCount()
+	packet.SetMaxFrameSize(uint32(conf.GetInt("max_frame_size")))
 	return p
 }
 
@@ -38,6 +73,12 @@ func (p *Proxy) Start() {
 }
 
 func (p *Proxy) start() {
+	//连接所有的cache server节点.
+	p.getCacheServerList()
+	//加载插槽路由表并监听插槽的变化,路由表加载完成之后才接受客户端连接.
+	rev := p.getSlotList()
+	go p.slotWatch(rev)
+
 	//监听tcp端口.
 	go p.checkProxyStart()
 	p.listen()
@@ -47,25 +88,63 @@ func (p *Proxy) start() {
 func (p *Proxy) connCacheServer(ip string) {
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
-	conn, err := net.Dial("tcp4", ip)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
+	if _, ok := p.CacheServer[ip]; ok {
 		return
 	}
 
-	log.Println("cache server ip:", ip, "连接成功!")
-	p.CacheServer[ip] = conn
+	pool := NewPool(ip, p.PoolOptions)
+	if _, err := pool.Get(); err != nil {
+		log.Printf("err:%+v\n", err)
+	} else {
+		log.Println("cache server ip:", ip, "连接成功!")
+	}
+	p.CacheServer[ip] = pool
 }
 
 //removeCacheServer 移除cache server.
 func (p *Proxy) removeCacheServer(ip string) {
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
-	p.CacheServer[ip].Close()
+	pool, ok := p.CacheServer[ip]
+	if !ok {
+		return
+	}
+	pool.Close()
 	delete(p.CacheServer, ip)
+	delete(p.Masters, ip)
 	log.Println("cache server ip:", ip, "移除成功!")
 }
 
+//setMaster 更新cache server的主节点.
+func (p *Proxy) setMaster(node base.CacheServer) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	if len(node.Master) < 1 {
+		delete(p.Masters, node.IP)
+		return
+	}
+	p.Masters[node.IP] = node.Master
+}
+
+//masterOf 返回处理ip上插槽的节点.
+//故障切换时新的主节点先在一个事务中将原主节点改为自己的从节点,之后再分批修改插槽,
+//期间仍然指向原主节点的插槽路由到新的主节点,不会访问已经下线的原主节点.
+func (p *Proxy) masterOf(ip string) string {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+	if master, ok := p.Masters[ip]; ok {
+		return master
+	}
+	return ip
+}
+
+//getCacheServer 根据ip获取cache server 连接池.
+func (p *Proxy) getCacheServer(ip string) *Pool {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+	return p.CacheServer[ip]
+}
+
 //checkProxyStart 检查是否启动成功.
 func (p *Proxy) checkProxyStart() {
 	for {
@@ -74,10 +153,10 @@ func (p *Proxy) checkProxyStart() {
 			if msg {
 				//打印欢迎界面.
 				p.welcome()
-				//连接所有的cache server节点.
-				p.getCacheServerList()
+				//注册到etcd,供admin和客户端发现.
+				go p.register()
 				//监听是否有新的cache server节点添加.
-				p.etcdWatch()
+				p.nodeWatch()
 			} else {
 				log.Println("启动失败")
 			}
@@ -113,8 +192,16 @@ func (p *Proxy) listen() {
 }
 
 //handler 处理请求.
+//连续发送的命令通过流水线并发处理,回复按请求顺序返回.
 func (p *Proxy) handler(cli *Client) {
+	atomic.AddInt64(&p.clients, 1)
+	defer atomic.AddInt64(&p.clients, -1)
+
 	redis := p.NewReais(cli)
+	pipe := redis.newPipeline(p.PipelineSize)
+	go pipe.reply(cli.Conn)
+	defer close(pipe.queue)
+
 	for {
 		//解析redis协议.
 		proto, err := redis.Parse()
@@ -128,14 +215,7 @@ func (p *Proxy) handler(cli *Client) {
 			}
 		}
 
-		//根据key获取插槽信息.
-		slot, err := p.getSlot(proto)
-		if err != nil {
-			redis.error(err.Error())
-			continue
-		}
-
-		redis.service(proto, slot)
+		pipe.dispatch(proto)
 	}
 }
 
@@ -2,7 +2,7 @@ package handler
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -20,6 +20,10 @@ import (
 type Redis struct {
 	reader *bufio.Reader
 	conn   net.Conn
+	writer io.Writer //回复写入的位置,流水线中每个命令写入各自的缓冲区.
+	proxy  *Proxy
+	epoch  uint64 //命令所在插槽在路由表中的epoch,随请求发送到cache server.
+	moved  bool   //cache server返回了MOVED,路由表已经刷新,命令需要重新执行.
 }
 
 type RedisEngine interface {
@@ -33,6 +37,8 @@ func (p *Proxy) NewReais(cli *Client) *Redis {
 	r := &Redis{
 		reader: cli.Reader,
 		conn:   cli.Conn,
+		writer: cli.Conn,
+		proxy:  p,
 	}
 	return r
 }
@@ -50,6 +56,59 @@ const (
 	TypeArray     = '*'
 )
 
+//keyCommands 第一个参数为key的命令及其最少参数个数,这些命令根据key所在的插槽路由到cache server.
+var keyCommands = map[string]int{
+	"GET":           1,
+	"SET":           2,
+	"DEL":           1,
+	"EXPIRE":        2,
+	"PEXPIRE":       2,
+	"EXPIREAT":      2,
+	"PEXPIREAT":     2,
+	"TTL":           1,
+	"PTTL":          1,
+	"PERSIST":       1,
+	"INCR":          1,
+	"DECR":          1,
+	"INCRBY":        2,
+	"DECRBY":        2,
+	"INCRBYFLOAT":   2,
+	"HSET":          3,
+	"HGET":          2,
+	"HMGET":         2,
+	"HGETALL":       1,
+	"HDEL":          2,
+	"HINCRBY":       3,
+	"HLEN":          1,
+	"LPUSH":         2,
+	"RPUSH":         2,
+	"LPOP":          1,
+	"RPOP":          1,
+	"LRANGE":        3,
+	"LLEN":          1,
+	"LTRIM":         3,
+	"SADD":          2,
+	"SREM":          2,
+	"SMEMBERS":      1,
+	"SISMEMBER":     2,
+	"SCARD":         1,
+	"SINTER":        1,
+	"SUNION":        1,
+	"ZADD":          3,
+	"ZRANGE":        3,
+	"ZRANGEBYSCORE": 3,
+	"ZREM":          2,
+	"ZSCORE":        2,
+	"ZINCRBY":       3,
+	"ZCARD":         1,
+}
+
+//multiKeyCommands 所有参数都是key的命令,所有key需要在同一个插槽中.
+var multiKeyCommands = map[string]bool{
+	"SINTER": true,
+	"SUNION": true,
+}
+
 //解析redis协议.
 func (r *Redis) Parse() (proto RedisProto, err error) {
 	line, err := r.reader.ReadString('\n')
@@ -112,16 +171,16 @@ func (r *Redis) read() (b []byte, err error) {
 
 //connection 连接成功.
 func (r *Redis) connection() {
-	r.conn.Write([]byte("+OK\r\n"))
+	r.writer.Write([]byte("+OK\r\n"))
 }
 
 //.ping
 func (r *Redis) ping() {
-	r.conn.Write([]byte("+PONG\r\n"))
+	r.writer.Write([]byte("+PONG\r\n"))
 }
 
 func (r *Redis) error(msg string) {
-	r.conn.Write([]byte(fmt.Sprintf("-%s\r\n", msg)))
+	r.writer.Write([]byte(fmt.Sprintf("-%s\r\n", msg)))
 }
 
 func (r *Redis) write(msg string, l int) {
@@ -131,38 +190,67 @@ func (r *Redis) write(msg string, l int) {
 		//不存在的key 返回nil.
 		msg = fmt.Sprintf("$%d\r\n", l)
 	}
-	r.conn.Write([]byte(msg))
+	r.writer.Write([]byte(msg))
 }
 
 func (r *Redis) int(n int) {
 	msg := fmt.Sprintf(":%d\r\n", n)
-	r.conn.Write([]byte(msg))
+	r.writer.Write([]byte(msg))
 }
 
-func (r *Redis) set(srv net.Conn, args [][]byte) {
-	key := string(args[0])
-	val := string(args[1])
-
-	content := []string{
-		key,
-		val,
-	}
-	b, err := json.Marshal(content)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
+//array 返回多条数据,nil表示不存在.
+func (r *Redis) array(list []*string) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(list))
+	for _, item := range list {
+		if item == nil {
+			buf.WriteString("$-1\r\n")
+			continue
+		}
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(*item), *item)
 	}
+	r.writer.Write(buf.Bytes())
+}
 
-	buf := packet.NewRequest(b, packet.WRITE)
-	_, err = srv.Write([]byte(buf))
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
+//set 支持 EX seconds、PX milliseconds、NX、XX 参数.
+func (r *Redis) set(srv *Pool, args [][]byte) {
+	var ttl int64
+	var cond string
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "NX", "XX":
+			if cond != "" {
+				r.error("ERR syntax error")
+				return
+			}
+			cond = option
+		case "EX", "PX":
+			if ttl != 0 || i+1 >= len(args) {
+				r.error("ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				r.error(errcode.MSG_NOT_INTEGER)
+				return
+			}
+			if n <= 0 {
+				r.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			if option == "EX" {
+				n = n * 1000
+			}
+			ttl = n
+		default:
+			r.error("ERR syntax error")
+			return
+		}
 	}
 
-	pkt, err := packet.ParseResponse(srv)
+	pkt, err := r.request(srv, packet.WRITE, string(args[0]), string(args[1]), strconv.FormatInt(ttl, 10), cond)
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		r.error(err.Error())
@@ -170,6 +258,11 @@ func (r *Redis) set(srv net.Conn, args [][]byte) {
 	}
 
 	if pkt.Err != errcode.NO_ERROR {
+		//NX、XX条件不满足返回nil.
+		if pkt.Err == errcode.NOT_SET {
+			r.write(pkt.Msg, -1)
+			return
+		}
 		r.error(pkt.Msg)
 		return
 	}
@@ -177,27 +270,8 @@ func (r *Redis) set(srv net.Conn, args [][]byte) {
 }
 
 //先读取新节点.
-func (r *Redis) get(srv net.Conn, args [][]byte) {
-	key := string(args[0])
-	content := []string{
-		key,
-	}
-	b, err := json.Marshal(content)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	buf := packet.NewRequest(b, packet.READ)
-	_, err = srv.Write([]byte(buf))
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	pkt, err := packet.ParseResponse(srv)
+func (r *Redis) get(srv *Pool, args [][]byte) {
+	pkt, err := r.request(srv, packet.READ, string(args[0]))
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		r.error(err.Error())
@@ -215,66 +289,24 @@ func (r *Redis) get(srv net.Conn, args [][]byte) {
 	r.write(pkt.Msg, len(pkt.Msg))
 }
 
-func (r *Redis) del(srv net.Conn, args [][]byte) {
-	key := string(args[0])
-	content := []string{
-		key,
-	}
-	b, err := json.Marshal(content)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	buf := packet.NewRequest(b, packet.DELETE)
-	_, err = srv.Write([]byte(buf))
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	pkt, err := packet.ParseResponse(srv)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
+//request 向cache server 发送请求并读取返回结果.
+//cache server返回MOVED时刷新路由表中的插槽,由调用方重新执行命令.
+func (r *Redis) request(srv *Pool, num packet.BigcacheProtocol, args ...string) (pkt packet.Response, err error) {
+	if srv == nil {
+		return pkt, errors.New("cache server 未连接")
 	}
 
-	if pkt.Err != errcode.NO_ERROR {
-		r.error(pkt.Msg)
-		return
+	pkt, err = srv.Do(num, r.epoch, packet.EncodeArgs(args...))
+	if err == nil && pkt.Err == errcode.MOVED {
+		r.moved = true
+		r.proxy.refreshSlot(pkt.Msg)
 	}
-	r.int(1)
+	return pkt, err
 }
 
-//getMigrate 处理迁移状态的get命令.
-//如果插槽处于迁移状态下，先读取新迁移的节点，如果没读取到，在读取旧的节点.
-func (r *Redis) getMigrate(srv, newSrv net.Conn, args [][]byte) {
-	//读取新节点.
-	// newSrv.
-	//新节点如果没有读取到，在读取旧节点.
-	key := string(args[0])
-	content := []string{
-		key,
-	}
-	b, err := json.Marshal(content)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	buf := packet.NewRequest(b, packet.READ)
-	_, err = newSrv.Write([]byte(buf))
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	pkt, err := packet.ParseResponse(srv)
+//listRequest 发送请求,并将cache server 返回的json列表作为数组回复.
+func (r *Redis) listRequest(srv *Pool, num packet.BigcacheProtocol, args ...string) {
+	pkt, err := r.request(srv, num, args...)
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		r.error(err.Error())
@@ -282,57 +314,26 @@ func (r *Redis) getMigrate(srv, newSrv net.Conn, args [][]byte) {
 	}
 
 	if pkt.Err != errcode.NO_ERROR {
-		if pkt.Err == errcode.NOT_FOUND {
-			//新节点没有读取到数据，现在读取旧节点数据.
-			r.get(srv, args)
-			return
-		}
 		r.error(pkt.Msg)
 		return
 	}
-	r.write(pkt.Msg, len(pkt.Msg))
-}
-
-//delMigrate 插槽处于迁移状态下的删除操作.
-func (r *Redis) delMigrate(srv, newSrv net.Conn, args [][]byte) {
-	//先确定数据存在与那个节点.
-	key := string(args[0])
-	content := []string{
-		key,
-	}
-	b, err := json.Marshal(content)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
 
-	buf := packet.NewRequest(b, packet.READ)
-	_, err = newSrv.Write([]byte(buf))
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		r.error(err.Error())
-		return
-	}
-
-	pkt, err := packet.ParseResponse(srv)
+	list, err := packet.DecodeArray([]byte(pkt.Msg))
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		r.error(err.Error())
 		return
 	}
+	r.array(list)
+}
 
-	if pkt.Err != errcode.NO_ERROR {
-		if pkt.Err == errcode.NOT_FOUND {
-			//新节点没有读取到数据，现在读取旧节点数据.
-			r.del(srv, args)
-			return
-		}
-		r.error(pkt.Msg)
-		return
+//stringArgs 将命令参数转换为字符串.
+func stringArgs(args [][]byte) []string {
+	list := make([]string, len(args))
+	for i, arg := range args {
+		list[i] = string(arg)
 	}
-
-	r.del(newSrv, args)
+	return list
 }
 
 //selectdb .
@@ -366,36 +367,115 @@ func (r *Redis) service(proto RedisProto, slot base.Slot) {
 		return
 	}
 
-	//set 并且当前插槽不处于迁移状态.
-	if proto.Command == "SET" && slot.Types == base.SLOT_TYPE_NORMAL {
-		r.set(slot.Conn, proto.Args)
-		return
-	}
-
-	if proto.Command == "SET" && slot.Types == base.SLOT_TYPE_MIGRATE {
-		r.set(slot.NewConn, proto.Args)
+	if _, ok := keyCommands[proto.Command]; !ok {
+		r.error("暂不支持当前命令")
 		return
 	}
 
-	if proto.Command == "GET" && slot.Types == base.SLOT_TYPE_NORMAL {
-		r.get(slot.Conn, proto.Args)
-		return
-	}
+	srv := r.proxy.getCacheServer(slot.IP)
 
-	if proto.Command == "GET" && slot.Types == base.SLOT_TYPE_MIGRATE {
-		r.getMigrate(slot.Conn, slot.NewConn, proto.Args)
-		return
-	}
-
-	if proto.Command == "DEL" && slot.Types == base.SLOT_TYPE_NORMAL {
-		r.del(slot.Conn, proto.Args)
-		return
-	}
+	//插槽处于迁移状态时,先将key迁移到新节点,之后只操作新节点.
+	if slot.Types == base.SLOT_TYPE_MIGRATE {
+		keys := proto.Args[:1]
+		if multiKeyCommands[proto.Command] {
+			keys = proto.Args
+		}
 
-	if proto.Command == "DEL" && slot.Types == base.SLOT_TYPE_MIGRATE {
-		r.delMigrate(slot.Conn, slot.NewConn, proto.Args)
-		return
+		newSrv := r.proxy.getCacheServer(slot.NewIP)
+		for _, key := range keys {
+			if err := r.migrateKey(srv, newSrv, string(key)); err != nil {
+				log.Printf("err:%+v\n", err)
+				r.error(err.Error())
+				return
+			}
+		}
+		srv = newSrv
+	}
+
+	switch proto.Command {
+	case "SET":
+		r.set(srv, proto.Args)
+	case "GET":
+		r.get(srv, proto.Args)
+	case "EXPIRE":
+		r.expire(srv, proto.Args, packet.EXPIRE, 1000)
+	case "PEXPIRE":
+		r.expire(srv, proto.Args, packet.EXPIRE, 1)
+	case "EXPIREAT":
+		r.expire(srv, proto.Args, packet.EXPIRE_AT, 1000)
+	case "PEXPIREAT":
+		r.expire(srv, proto.Args, packet.EXPIRE_AT, 1)
+	case "TTL":
+		r.ttl(srv, proto.Args, 1000)
+	case "PTTL":
+		r.ttl(srv, proto.Args, 1)
+	case "PERSIST":
+		r.persist(srv, proto.Args)
+	case "INCR":
+		r.incr(srv, proto.Args, 1)
+	case "DECR":
+		r.incr(srv, proto.Args, -1)
+	case "INCRBY":
+		r.incrby(srv, proto.Args, 1)
+	case "DECRBY":
+		r.incrby(srv, proto.Args, -1)
+	case "INCRBYFLOAT":
+		r.incrbyfloat(srv, proto.Args)
+	case "HSET":
+		r.hset(srv, proto.Args)
+	case "HGET":
+		r.hget(srv, proto.Args)
+	case "HMGET":
+		r.listRequest(srv, packet.HMGET, stringArgs(proto.Args)...)
+	case "HGETALL":
+		r.listRequest(srv, packet.HGETALL, string(proto.Args[0]))
+	case "HDEL":
+		r.intRequest(srv, packet.HDEL, stringArgs(proto.Args)...)
+	case "HINCRBY":
+		r.hincrby(srv, proto.Args)
+	case "HLEN":
+		r.intRequest(srv, packet.HLEN, string(proto.Args[0]))
+	case "LPUSH":
+		r.intRequest(srv, packet.LPUSH, stringArgs(proto.Args)...)
+	case "RPUSH":
+		r.intRequest(srv, packet.RPUSH, stringArgs(proto.Args)...)
+	case "LPOP":
+		r.pop(srv, proto.Args, packet.LPOP)
+	case "RPOP":
+		r.pop(srv, proto.Args, packet.RPOP)
+	case "LRANGE":
+		r.lrange(srv, proto.Args)
+	case "LLEN":
+		r.intRequest(srv, packet.LLEN, string(proto.Args[0]))
+	case "LTRIM":
+		r.ltrim(srv, proto.Args)
+	case "SADD":
+		r.intRequest(srv, packet.SADD, stringArgs(proto.Args)...)
+	case "SREM":
+		r.intRequest(srv, packet.SREM, stringArgs(proto.Args)...)
+	case "SMEMBERS":
+		r.listRequest(srv, packet.SMEMBERS, string(proto.Args[0]))
+	case "SISMEMBER":
+		r.intRequest(srv, packet.SISMEMBER, string(proto.Args[0]), string(proto.Args[1]))
+	case "SCARD":
+		r.intRequest(srv, packet.SCARD, string(proto.Args[0]))
+	case "SINTER":
+		r.listRequest(srv, packet.SINTER, stringArgs(proto.Args)...)
+	case "SUNION":
+		r.listRequest(srv, packet.SUNION, stringArgs(proto.Args)...)
+	case "ZADD":
+		r.zadd(srv, proto.Args)
+	case "ZRANGE":
+		r.zrange(srv, proto.Args)
+	case "ZRANGEBYSCORE":
+		r.zrangebyscore(srv, proto.Args)
+	case "ZREM":
+		r.intRequest(srv, packet.ZREM, stringArgs(proto.Args)...)
+	case "ZSCORE":
+		r.zscore(srv, packet.ZSCORE, proto.Args[:2])
+	case "ZINCRBY":
+		r.zincrby(srv, proto.Args[:3])
+	case "ZCARD":
+		r.intRequest(srv, packet.ZCARD, string(proto.Args[0]))
 	}
-
-	r.error("暂不支持当前命令")
 }
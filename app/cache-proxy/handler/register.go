@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/registry"
+)
+
+//REPORT_INTERVAL 上报连接的客户端数量的间隔,数量没有变化时不上报.
+const REPORT_INTERVAL = time.Second
+
+//register 将当前proxy注册到注册中心,注册信息绑定租约,停止心跳之后自动删除.
+func (p *Proxy) register() {
+	for {
+		err := p.keepAlive()
+		log.Println("proxy注册中断:", err)
+		time.Sleep(time.Second)
+	}
+}
+
+//keepAlive 创建租约并写入注册信息,定时更新连接的客户端数量,租约过期或者连接中断时返回.
+func (p *Proxy) keepAlive() error {
+	clients := atomic.LoadInt64(&p.clients)
+	lease, err := p.Registry.Grant(registry.LEASE_PROXY, p.AdvertiseAddr, p.info(clients), p.HeartbeatTTL)
+	if err != nil {
+		return err
+	}
+	defer lease.Close()
+
+	ticker := time.NewTicker(REPORT_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.Done():
+			return errors.New("租约已过期")
+		case <-ticker.C:
+			n := atomic.LoadInt64(&p.clients)
+			if n == clients {
+				continue
+			}
+			if err := lease.Update(p.info(n)); err != nil {
+				return err
+			}
+			clients = n
+		}
+	}
+}
+
+//info proxy的注册信息.
+func (p *Proxy) info(clients int64) string {
+	b, _ := json.Marshal(base.ProxyServer{
+		Addr:    p.AdvertiseAddr,
+		Clients: clients,
+		Version: base.VERSION,
+	})
+	return string(b)
+}
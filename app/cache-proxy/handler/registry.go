@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//MAX_REDIRECTS cache server返回MOVED之后重新执行命令的最大次数.
+const MAX_REDIRECTS = 3
+
+//REDIRECT_WAIT 注册中心中的插槽比cache server旧时,重试之前等待的时间.
+const REDIRECT_WAIT = 100 * time.Millisecond
+
+//loadSlotCount 从注册中心加载集群的插槽数量.
+func (p *Proxy) loadSlotCount() {
+	utils.SetSlotCount(uint32(conf.GetInt("slot_count")))
+	count, err := p.Registry.SlotCount(utils.SlotCount())
+	if err != nil {
+		panic(err)
+	}
+	utils.SetSlotCount(count)
+}
+
+//getCacheServerList 获取所有的cache server.
+func (p *Proxy) getCacheServerList() {
+	list, err := p.Registry.Nodes()
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+
+	for _, cacheServer := range list {
+		log.Printf("cacheServer:%+v\n", cacheServer)
+		p.connCacheServer(cacheServer.IP)
+		p.setMaster(cacheServer)
+	}
+}
+
+//nodeWatch 监听cache server 是否有改变.
+func (p *Proxy) nodeWatch() {
+	for ev := range p.Registry.WatchNodes() {
+		switch ev.Type {
+		case registry.EVENT_PUT:
+			log.Println("新增加cache server 节点:", ev.Node.IP)
+			p.connCacheServer(ev.Node.IP)
+			p.setMaster(ev.Node)
+		case registry.EVENT_DELETE:
+			log.Println("移除cache server 节点:", ev.Node.IP)
+			p.removeCacheServer(ev.Node.IP)
+		}
+	}
+}
+
+//getSlotList 加载所有的插槽信息到路由表,返回当前的版本号.
+//加载失败时一直重试,没有路由表时不能处理请求,也不能从错误的版本号开始监听.
+func (p *Proxy) getSlotList() int64 {
+	for {
+		list, rev, err := p.Registry.Slots()
+		if err == nil {
+			p.replaceSlots(list)
+			return rev
+		}
+		log.Printf("err:%+v\n", err)
+		time.Sleep(time.Second)
+	}
+}
+
+//replaceSlots 用list替换整个路由表.
+func (p *Proxy) replaceSlots(list []base.Slot) {
+	table := make(map[int]base.Slot, len(list))
+	for _, slot := range list {
+		table[slot.ID] = slot
+	}
+
+	p.SlotLock.Lock()
+	p.SlotTable = table
+	p.SlotLock.Unlock()
+	log.Println("加载插槽信息:", len(table))
+}
+
+//slotWatch 监听插槽的变化并更新路由表.
+//从加载路由表时的版本号开始监听,保证不会遗漏中间的变化.
+func (p *Proxy) slotWatch(rev int64) {
+	for ev := range p.Registry.WatchSlots(rev) {
+		p.updateSlot(ev)
+	}
+}
+
+//updateSlot 根据插槽的变化更新路由表,忽略epoch较小的变化.
+func (p *Proxy) updateSlot(ev registry.SlotEvent) {
+	if ev.Type == registry.EVENT_RELOAD {
+		p.replaceSlots(ev.Slots)
+		return
+	}
+
+	p.SlotLock.Lock()
+	defer p.SlotLock.Unlock()
+
+	if old, ok := p.SlotTable[ev.Slot.ID]; ok && old.Epoch > ev.Slot.Epoch {
+		return
+	}
+	if ev.Type == registry.EVENT_DELETE {
+		delete(p.SlotTable, ev.Slot.ID)
+		log.Println("移除插槽:", ev.Slot.ID)
+		return
+	}
+	p.SlotTable[ev.Slot.ID] = ev.Slot
+}
+
+//refreshSlot cache server返回MOVED时从注册中心重新读取插槽.
+//注册中心中的插槽还没有更新到MOVED中的epoch时等待一段时间,之后由调用方重试.
+func (p *Proxy) refreshSlot(msg string) {
+	id, ip, epoch, err := packet.ParseMoved(msg)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+	log.Println("路由表已过期, 插槽:", id, "当前节点:", ip, "epoch:", epoch)
+
+	slot, err := p.Registry.GetSlot(id)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+	p.updateSlot(registry.SlotEvent{Type: registry.EVENT_PUT, Slot: slot})
+
+	if slot.Epoch < epoch {
+		time.Sleep(REDIRECT_WAIT)
+	}
+}
+
+//getSlot 从路由表中根据插槽获取插槽信息.
+func (p *Proxy) getSlot(proto RedisProto) (slot base.Slot, err error) {
+	if n, ok := keyCommands[proto.Command]; ok {
+		if len(proto.Args) < n {
+			return slot, fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(proto.Command))
+		}
+
+		//多个key的命令只能操作同一个插槽中的key.
+		if multiKeyCommands[proto.Command] {
+			slotid := utils.Slot(string(proto.Args[0]))
+			for _, arg := range proto.Args[1:] {
+				if utils.Slot(string(arg)) != slotid {
+					return slot, errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+				}
+			}
+		}
+
+		return p.slotOf(string(proto.Args[0]))
+	}
+	return slot, nil
+
+}
+
+//slotOf 根据key获取插槽信息.
+//key中包含{...}时只根据花括号中的内容计算插槽,插槽指向的节点已经成为从节点时改为指向其主节点.
+func (p *Proxy) slotOf(key string) (base.Slot, error) {
+	slotid := int(utils.Slot(key))
+
+	p.SlotLock.RLock()
+	slot, ok := p.SlotTable[slotid]
+	p.SlotLock.RUnlock()
+	if !ok {
+		return slot, fmt.Errorf("插槽%d未分配", slotid)
+	}
+
+	slot.IP = p.masterOf(slot.IP)
+	if len(slot.NewIP) > 0 {
+		slot.NewIP = p.masterOf(slot.NewIP)
+	}
+	return slot, nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/registry"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//newTestProxy 不连接注册中心和cache server的proxy.
+func newTestProxy() *Proxy {
+	return &Proxy{
+		Lock:        &sync.RWMutex{},
+		CacheServer: make(map[string]*Pool),
+		Masters:     make(map[string]string),
+		SlotLock:    &sync.RWMutex{},
+		SlotTable:   make(map[int]base.Slot),
+	}
+}
+
+//TestUpdateSlot 路由表忽略epoch较小的变化,重新加载时替换整个路由表.
+func TestUpdateSlot(t *testing.T) {
+	p := newTestProxy()
+	id := int(utils.Slot("k"))
+	p.replaceSlots([]base.Slot{{ID: id, IP: "a", Epoch: 2}, {ID: id + 1, IP: "a", Epoch: 2}})
+
+	p.updateSlot(registry.SlotEvent{Type: registry.EVENT_PUT, Slot: base.Slot{ID: id, IP: "b", Epoch: 1}})
+	if slot, err := p.slotOf("k"); err != nil || slot.IP != "a" {
+		t.Fatalf("旧的变化覆盖了路由表: %+v %v", slot, err)
+	}
+	p.updateSlot(registry.SlotEvent{Type: registry.EVENT_PUT, Slot: base.Slot{ID: id, IP: "b", Epoch: 3}})
+	if slot, err := p.slotOf("k"); err != nil || slot.IP != "b" {
+		t.Fatalf("新的变化没有生效: %+v %v", slot, err)
+	}
+
+	p.updateSlot(registry.SlotEvent{Type: registry.EVENT_RELOAD, Slots: []base.Slot{{ID: id, IP: "c", Epoch: 4}}})
+	if slot, err := p.slotOf("k"); err != nil || slot.IP != "c" {
+		t.Fatalf("重新加载: %+v %v", slot, err)
+	}
+	if _, ok := p.SlotTable[id+1]; ok {
+		t.Fatal("重新加载之后保留了已经删除的插槽")
+	}
+
+	p.updateSlot(registry.SlotEvent{Type: registry.EVENT_DELETE, Slot: base.Slot{ID: id, Epoch: 5}})
+	if _, err := p.slotOf("k"); err == nil {
+		t.Fatal("删除的插槽仍然可以路由")
+	}
+}
+
+//TestSlotOfDemotedMaster 插槽仍然指向已经成为从节点的原主节点时路由到新的主节点.
+func TestSlotOfDemotedMaster(t *testing.T) {
+	p := newTestProxy()
+	id := int(utils.Slot("k"))
+	p.replaceSlots([]base.Slot{{ID: id, Types: base.SLOT_TYPE_MIGRATE, IP: "a", NewIP: "c"}})
+
+	p.setMaster(base.CacheServer{IP: "a", Master: "b"})
+	slot, err := p.slotOf("k")
+	if err != nil || slot.IP != "b" || slot.NewIP != "c" {
+		t.Fatalf("原主节点降级: %+v %v", slot, err)
+	}
+
+	//原主节点重新成为主节点.
+	p.setMaster(base.CacheServer{IP: "a"})
+	if slot, _ := p.slotOf("k"); slot.IP != "a" {
+		t.Fatalf("恢复为主节点: %+v", slot)
+	}
+}
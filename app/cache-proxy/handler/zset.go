@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//zadd 处理 ZADD 命令,参数为key、score、member、score、member...
+func (r *Redis) zadd(srv *Pool, args [][]byte) {
+	if len(args)%2 == 0 {
+		r.error("ERR syntax error")
+		return
+	}
+
+	r.intRequest(srv, packet.ZADD, stringArgs(args)...)
+}
+
+//zscore 处理 ZSCORE、ZINCRBY 命令,成员不存在时返回nil.
+func (r *Redis) zscore(srv *Pool, num packet.BigcacheProtocol, args [][]byte) {
+	pkt, err := r.request(srv, num, stringArgs(args)...)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		if pkt.Err == errcode.NOT_FOUND {
+			r.write(pkt.Msg, -1)
+			return
+		}
+		r.error(pkt.Msg)
+		return
+	}
+	r.write(pkt.Msg, len(pkt.Msg))
+}
+
+//zrange 处理 ZRANGE 命令,支持 WITHSCORES 参数.
+func (r *Redis) zrange(srv *Pool, args [][]byte) {
+	if !r.checkIndex(args[1:]) {
+		return
+	}
+
+	withScores := false
+	for _, arg := range args[3:] {
+		if strings.ToUpper(string(arg)) != "WITHSCORES" {
+			r.error("ERR syntax error")
+			return
+		}
+		withScores = true
+	}
+
+	r.zsetRequest(srv, packet.ZRANGE, withScores, string(args[0]), string(args[1]), string(args[2]))
+}
+
+//zrangebyscore 处理 ZRANGEBYSCORE 命令,支持 WITHSCORES、LIMIT offset count 参数.
+func (r *Redis) zrangebyscore(srv *Pool, args [][]byte) {
+	withScores := false
+	offset, count := "0", "-1"
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				r.error("ERR syntax error")
+				return
+			}
+			if !r.checkIndex(args[i+1:]) {
+				return
+			}
+			offset, count = string(args[i+1]), string(args[i+2])
+			i += 2
+		default:
+			r.error("ERR syntax error")
+			return
+		}
+	}
+
+	r.zsetRequest(srv, packet.ZRANGE_BY_SCORE, withScores, string(args[0]), string(args[1]), string(args[2]), offset, count)
+}
+
+//zsetRequest 发送请求,cache server 返回成员和分数交替排列的列表,withScores为false时只回复成员.
+func (r *Redis) zsetRequest(srv *Pool, num packet.BigcacheProtocol, withScores bool, args ...string) {
+	pkt, err := r.request(srv, num, args...)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		r.error(pkt.Msg)
+		return
+	}
+
+	list, err := packet.DecodeArray([]byte(pkt.Msg))
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		r.error(err.Error())
+		return
+	}
+
+	if !withScores {
+		members := make([]*string, 0, len(list)/2)
+		for i := 0; i < len(list); i += 2 {
+			members = append(members, list[i])
+		}
+		list = members
+	}
+	r.array(list)
+}
+
+//zincrby 处理 ZINCRBY 命令.
+func (r *Redis) zincrby(srv *Pool, args [][]byte) {
+	if _, err := strconv.ParseFloat(string(args[1]), 64); err != nil {
+		r.error(errcode.MSG_NOT_FLOAT)
+		return
+	}
+
+	r.zscore(srv, packet.ZINCRBY, args)
+}
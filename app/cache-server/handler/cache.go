@@ -1,42 +1,170 @@
 package handler
 
 import (
-	"encoding/json"
+	"bufio"
 	"io"
 	"log"
 	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"go.etcd.io/etcd/clientv3"
 
 	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/etcd"
 	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
+	"github.com/houzhongjian/bigcache/lib/utils"
 
 	"github.com/houzhongjian/bigcache/lib/conf"
 )
 
+//DEFAULT_MAX_INFLIGHT 单个连接同时处理的最大请求数的默认值.
+const DEFAULT_MAX_INFLIGHT = 1024
+
 type Cache struct {
-	Addr    string
-	Ch      chan bool
-	Storage StorageEngine
+	ID             uint //节点编号,为0时注册时自动分配.
+	Addr           string
+	Ch             chan bool
+	Storage        StorageEngine
+	Lock           *KeyLock
+	ExpireInterval time.Duration     //过期数据清理间隔.
+	Secret         string            //连接授权密钥,不能为空.
+	Repl           *Replication      //主从复制状态.
+	Etcd           *clientv3.Client  //未配置etcd时为nil,不注册节点也不启用自动故障切换.
+	Registry       registry.Registry //使用静态文件或gossip时的注册中心,不支持自动故障切换.
+	Topology       *Topology         //插槽路由表,配置了注册中心时不为nil.
+	HeartbeatTTL   int64             //存活状态租约的过期时间,单位秒.
+	FailoverWait   time.Duration     //选举时等待其他从节点参与的时间.
+	MaxTxnOps      int               //etcd单个事务的最大操作数.
+	Capacity       int               //节点容量,单位MB,0表示不限制.
+	MaxInflight    int               //多路复用时单个连接同时处理的最大请求数.
+	Targets        *MigrateTargets   //迁移单个key时与目标节点的连接.
 }
 
 //NewServer.
 func NewServer() Cache {
+	//所有连接都必须通过密钥握手,包括复制、迁移等管理协议,没有配置密钥时不启动.
+	if len(conf.GetString("auth_secret")) < 1 {
+		log.Printf("err:%+v\n", packet.ErrNoSecret)
+		os.Exit(1)
+	}
+
+	reg := newRegistry()
+	loadSlotCount(reg)
+	packet.SetMaxFrameSize(uint32(conf.GetInt("max_frame_size")))
+
 	cache := Cache{
-		Addr:    conf.GetString("addr"),
-		Ch:      make(chan bool),
-		Storage: NewStorage(conf.GetString("storage_dir")),
+		ID:             uint(conf.GetInt("server_id")),
+		Addr:           conf.GetString("addr"),
+		Ch:             make(chan bool),
+		Storage:        NewStorage(conf.GetString("storage_dir"), newReplLog()),
+		Lock:           NewKeyLock(),
+		ExpireInterval: time.Duration(conf.GetInt("expire_interval")) * time.Millisecond,
+		Secret:         conf.GetString("auth_secret"),
+		Repl:           &Replication{lock: &sync.Mutex{}},
+		HeartbeatTTL:   int64(conf.GetInt("heartbeat_ttl")),
+		FailoverWait:   time.Duration(conf.GetInt("failover_wait")) * time.Millisecond,
+		MaxTxnOps:      conf.GetInt("etcd_max_txn_ops"),
+		Capacity:       conf.GetInt("capacity"),
+		MaxInflight:    conf.GetInt("max_inflight"),
+		Targets:        NewMigrateTargets(),
+	}
+	if cache.ExpireInterval <= 0 {
+		cache.ExpireInterval = time.Second
+	}
+	if cache.HeartbeatTTL <= 0 {
+		cache.HeartbeatTTL = 5
+	}
+	if cache.FailoverWait <= 0 {
+		cache.FailoverWait = time.Second * 2
+	}
+	if cache.MaxInflight <= 0 {
+		cache.MaxInflight = DEFAULT_MAX_INFLIGHT
+	}
+	if cache.MaxTxnOps < 2 {
+		cache.MaxTxnOps = etcd.DEFAULT_MAX_TXN_OPS
+	}
+	//使用etcd时通过etcd的事务注册和故障切换,使用静态文件或gossip时通过Registry加入集群.
+	if e, ok := reg.(*registry.Etcd); ok {
+		cache.Etcd = e.Client
+	} else {
+		cache.Registry = reg
 	}
 	return cache
 }
 
+//newRegistry 根据配置创建注册中心,与proxy使用相同的配置顺序,没有配置时返回nil.
+func newRegistry() registry.Registry {
+	reg, err := registry.New()
+	if err == registry.ErrNoRegistry {
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	return reg
+}
+
+//loadSlotCount 加载集群的插槽数量,配置了注册中心时以集群中的为准.
+//数据文件按插槽存放,插槽数量必须与proxy保持一致.
+func loadSlotCount(reg registry.Registry) {
+	utils.SetSlotCount(uint32(conf.GetInt("slot_count")))
+	if reg == nil {
+		return
+	}
+
+	count, err := reg.SlotCount(utils.SlotCount())
+	if err != nil {
+		panic(err)
+	}
+	utils.SetSlotCount(count)
+}
+
 //Start.
 func (cache *Cache) Start() {
 	cache.start()
 }
 
 func (cache *Cache) start() {
+	//配置了etcd时将当前节点注册到etcd,使用静态文件或gossip时写入注册中心.
+	if cache.Etcd != nil {
+		if err := cache.register(); err != nil {
+			panic(err)
+		}
+	}
+	if cache.Registry != nil {
+		if err := cache.join(); err != nil {
+			panic(err)
+		}
+	}
+
+	//配置了主节点时作为从节点启动.
+	master, rev := cache.loadMaster()
+	if err := cache.setMaster(master); err != nil {
+		log.Printf("err:%+v\n", err)
+	}
+
+	//配置了注册中心时监听插槽的变化,拒绝路由表过期的请求.
+	if reg := cache.topologyRegistry(); reg != nil {
+		cache.Topology = loadTopology(reg)
+	}
+
+	//配置了etcd时保持心跳并在主节点下线时自动切换.
+	if cache.Etcd != nil {
+		go cache.heartbeat()
+		go cache.nodeWatch(rev)
+		go cache.checkMaster()
+	}
+	if cache.Registry != nil {
+		go cache.gossipWatch()
+	}
+
 	go cache.checkServerStart()
+	go cache.expireCycle()
 	cache.listen()
 }
 
@@ -80,36 +208,213 @@ func (cache *Cache) listen() {
 }
 
 func (cache *Cache) handler(cli *Client) {
+	defer cli.Conn.Close()
+
+	reader := bufio.NewReader(cli.Conn)
 	for {
-		pkt, err := packet.ParseRequest(cli.Conn)
+		pkt, err := cli.Handshake.ParseRequest(reader)
 		if err != nil {
-			log.Printf("err:%+v\n", err)
 			if err == io.EOF {
 				log.Println("断开连接!")
 				return
 			}
-			cli.Write(err.Error(), errcode.INFO)
+			//数据包错误时之后的数据已经无法解析,返回错误后关闭连接.
+			log.Println(cli.IP, "数据包错误:", err)
+			cli.WithID(pkt.ID).Write(err.Error(), errcode.PROTOCOL_ERROR)
+			return
+		}
+
+		if pkt.Protocol == packet.CONN_AUTH {
+			if !cache.auth(pkt, cli) {
+				return
+			}
 			continue
 		}
 
-		switch pkt.Protocol {
-		case packet.WRITE:
-			cache.Write(pkt.Body, cli)
-		case packet.READ:
-			cache.Read(pkt.Body, cli)
-		case packet.DELETE:
-			cache.Delete(pkt.Body, cli)
+		//必须先完成握手.
+		if !cli.authed {
+			cli.WithID(pkt.ID).Write(packet.ErrAuth.Error(), errcode.AUTH_FAILED)
+			return
+		}
+
+		//协商了CAP_EPOCH时请求内容前附加了proxy路由表的epoch.
+		var epoch uint64
+		if cli.Handshake.Has(packet.CAP_EPOCH) {
+			if epoch, pkt.Body, err = packet.SplitEpoch(pkt.Body); err != nil {
+				log.Println(cli.IP, "数据包错误:", err)
+				cli.WithID(pkt.ID).Write(err.Error(), errcode.PROTOCOL_ERROR)
+				return
+			}
+			pkt.Size = int64(len(pkt.Body))
+		}
+
+		//同步请求之后连接只用于向从节点推送数据.
+		if pkt.Protocol == packet.REPL_SYNC {
+			cache.replicate(pkt, reader, cli.WithID(pkt.ID))
+			return
+		}
+
+		//协商了多路复用时请求并发处理,处理完成后按请求ID返回,返回顺序与请求顺序无关.
+		//同时处理的请求达到上限时停止读取,直到有请求处理完成.
+		//否则按请求顺序依次处理.
+		if cli.Handshake.Has(packet.CAP_MULTIPLEX) {
+			cli.inflight <- struct{}{}
+			go func(pkt packet.Request, epoch uint64) {
+				defer func() { <-cli.inflight }()
+				cache.serve(pkt, epoch, cli.WithID(pkt.ID))
+			}(pkt, epoch)
+		} else {
+			cache.serve(pkt, epoch, cli.WithID(pkt.ID))
 		}
 	}
 }
 
+//auth 处理握手请求,校验失败时返回false,连接会被关闭.
+func (cache *Cache) auth(pkt packet.Request, cli *Client) bool {
+	h, msg, err := packet.Accept(pkt.Body, cache.Secret)
+	if err != nil {
+		log.Println(cli.IP, "握手失败:", err)
+		cli.WithID(pkt.ID).Write(err.Error(), errcode.AUTH_FAILED)
+		return false
+	}
+
+	//握手的回复使用不带校验值的数据包,之后的数据包使用协商的格式.
+	cli.WithID(pkt.ID).Write(msg, errcode.NO_ERROR)
+	cli.Handshake = h
+	cli.authed = true
+	return true
+}
+
+//serve 处理一个请求,epoch为发送方路由表的epoch,0表示不检查.
+func (cache *Cache) serve(pkt packet.Request, epoch uint64, cli *Client) {
+	if !cache.checkEpoch(pkt, epoch, cli) {
+		return
+	}
+
+	switch pkt.Protocol {
+	case packet.WRITE:
+		cache.Write(pkt.Body, cli)
+	case packet.READ:
+		cache.Read(pkt.Body, cli)
+	case packet.DELETE:
+		cache.Delete(pkt.Body, cli)
+	case packet.MIGRATE:
+		cache.Migrate(pkt.Body, cli)
+	case packet.MIGRATE_WRITE:
+		cache.MigrateWrite(pkt.Body, cli)
+	case packet.SLOT_KEYS:
+		cache.SlotKeys(pkt.Body, cli)
+	case packet.SLOT_COUNT:
+		cache.SlotCount(pkt.Body, cli)
+	case packet.MIGRATE_KEY:
+		cache.MigrateKey(pkt.Body, cli)
+	case packet.DUMP:
+		cache.Dump(pkt.Body, cli)
+	case packet.EXPIRE:
+		cache.Expire(pkt.Body, cli)
+	case packet.EXPIRE_AT:
+		cache.ExpireAt(pkt.Body, cli)
+	case packet.TTL:
+		cache.TTL(pkt.Body, cli)
+	case packet.PERSIST:
+		cache.Persist(pkt.Body, cli)
+	case packet.INCR:
+		cache.Incr(pkt.Body, cli)
+	case packet.INCR_FLOAT:
+		cache.IncrFloat(pkt.Body, cli)
+	case packet.HSET:
+		cache.HSet(pkt.Body, cli)
+	case packet.HGET:
+		cache.HGet(pkt.Body, cli)
+	case packet.HMGET:
+		cache.HMGet(pkt.Body, cli)
+	case packet.HGETALL:
+		cache.HGetAll(pkt.Body, cli)
+	case packet.HDEL:
+		cache.HDel(pkt.Body, cli)
+	case packet.HINCRBY:
+		cache.HIncrBy(pkt.Body, cli)
+	case packet.HLEN:
+		cache.HLen(pkt.Body, cli)
+	case packet.LPUSH:
+		cache.Push(pkt.Body, cli, true)
+	case packet.RPUSH:
+		cache.Push(pkt.Body, cli, false)
+	case packet.LPOP:
+		cache.Pop(pkt.Body, cli, true)
+	case packet.RPOP:
+		cache.Pop(pkt.Body, cli, false)
+	case packet.LRANGE:
+		cache.LRange(pkt.Body, cli)
+	case packet.LLEN:
+		cache.LLen(pkt.Body, cli)
+	case packet.LTRIM:
+		cache.LTrim(pkt.Body, cli)
+	case packet.SADD:
+		cache.SAdd(pkt.Body, cli)
+	case packet.SREM:
+		cache.SRem(pkt.Body, cli)
+	case packet.SMEMBERS:
+		cache.SMembers(pkt.Body, cli)
+	case packet.SISMEMBER:
+		cache.SIsMember(pkt.Body, cli)
+	case packet.SCARD:
+		cache.SCard(pkt.Body, cli)
+	case packet.SINTER:
+		cache.SInter(pkt.Body, cli)
+	case packet.SUNION:
+		cache.SUnion(pkt.Body, cli)
+	case packet.ZADD:
+		cache.ZAdd(pkt.Body, cli)
+	case packet.ZRANGE:
+		cache.ZRange(pkt.Body, cli)
+	case packet.ZRANGE_BY_SCORE:
+		cache.ZRangeByScore(pkt.Body, cli)
+	case packet.ZREM:
+		cache.ZRem(pkt.Body, cli)
+	case packet.ZSCORE:
+		cache.ZScore(pkt.Body, cli)
+	case packet.ZINCRBY:
+		cache.ZIncrBy(pkt.Body, cli)
+	case packet.ZCARD:
+		cache.ZCard(pkt.Body, cli)
+	case packet.MREAD:
+		cache.MRead(pkt.Body, cli)
+	case packet.MWRITE:
+		cache.MWrite(pkt.Body, cli)
+	case packet.REPLICA_OF:
+		cache.ReplicaOf(pkt.Body, cli)
+	case packet.REPL_INFO:
+		cache.ReplInfo(pkt.Body, cli)
+	case packet.PING:
+		cli.Write("PONG", errcode.NO_ERROR)
+	default:
+		cli.Write("不支持的协议", errcode.INFO)
+	}
+}
+
+//args 解析请求参数,参数个数小于n时返回错误.
+func (cache *Cache) args(body []byte, n int, cli *Client) (content []string, ok bool) {
+	content, err := packet.DecodeArgs(body)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return content, false
+	}
+
+	if len(content) < n {
+		cli.Write("参数错误", errcode.INFO)
+		return content, false
+	}
+
+	return content, true
+}
+
 //Read 读操作.
 func (cache *Cache) Read(body []byte, cli *Client) {
 	//读操作.
-	content := []string{}
-	if err := json.Unmarshal(body, &content); err != nil {
-		log.Printf("err:%+v\n", err)
-		cli.Write(err.Error(), errcode.INFO)
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
 		return
 	}
 
@@ -122,7 +427,7 @@ func (cache *Cache) Read(body []byte, cli *Client) {
 			return
 		}
 		log.Printf("err:%+v\n", err)
-		cli.Write(err.Error(), errcode.INFO)
+		cli.WriteError(err)
 		return
 	}
 
@@ -130,45 +435,140 @@ func (cache *Cache) Read(body []byte, cli *Client) {
 }
 
 //Write 写操作
+//请求参数为key、value、过期时间(毫秒,0表示永不过期)、写入条件(NX:key不存在时写入,XX:key存在时写入),后两个参数可选.
 func (cache *Cache) Write(body []byte, cli *Client) {
 	//写操作.
-	content := []string{}
-	if err := json.Unmarshal(body, &content); err != nil {
-		log.Printf("err:%+v\n", err)
-		cli.Write(err.Error(), errcode.INFO)
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
 		return
 	}
 
 	key := content[0]
 	val := content[1]
 
-	if err := cache.Storage.Write(key, val); err != nil {
+	var expireAt int64
+	if len(content) > 2 {
+		ttl, err := strconv.ParseInt(content[2], 10, 64)
+		if err != nil || ttl < 0 {
+			cli.Write("过期时间错误", errcode.INFO)
+			return
+		}
+		if ttl > 0 {
+			expireAt = Now() + ttl
+		}
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	if len(content) > 3 && content[3] != "" {
+		_, err := cache.Storage.ExpireAt(key)
+		if err != nil && err != leveldb.ErrNotFound {
+			cli.WriteError(err)
+			return
+		}
+
+		exists := err == nil
+		if (content[3] == "NX" && exists) || (content[3] == "XX" && !exists) {
+			cli.Write("NOT SET", errcode.NOT_SET)
+			return
+		}
+	}
+
+	if err := cache.Storage.Write(key, val, expireAt); err != nil {
 		log.Printf("err:%+v\n", err)
-		cli.Write(err.Error(), errcode.INFO)
+		cli.WriteError(err)
 		return
 	}
 
 	cli.Write("OK", errcode.NO_ERROR)
 }
 
-//Delete 删除操作.
+//Delete 删除操作,请求参数为key...,返回删除的条数.
 func (cache *Cache) Delete(body []byte, cli *Client) {
 	//删除操作.
-	content := []string{}
-	if err := json.Unmarshal(body, &content); err != nil {
-		log.Printf("err:%+v\n", err)
-		cli.Write(err.Error(), errcode.INFO)
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
 		return
 	}
 
-	key := content[0]
+	total := 0
+	for _, key := range content {
+		ok, err := cache.delete(key)
+		if err != nil {
+			log.Printf("err:%+v\n", err)
+			cli.WriteError(err)
+			return
+		}
+		if ok {
+			total++
+		}
+	}
+
+	cli.Write(strconv.Itoa(total), errcode.NO_ERROR)
+}
+
+//delete 删除一个key,返回key是否存在.
+func (cache *Cache) delete(key string) (bool, error) {
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
 
-	err := cache.Storage.Delete(key)
+	_, err := cache.Storage.ExpireAt(key)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
 	if err != nil {
-		log.Printf("err:%+v\n", err)
-		cli.Write(err.Error(), errcode.INFO)
+		return false, err
+	}
+
+	return true, cache.Storage.Delete(key)
+}
+
+//MRead 读取多个key,请求参数为key...,返回列表,不存在或者不是字符串类型的key为nil.
+func (cache *Cache) MRead(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	list := make([]*string, len(content))
+	for i, key := range content {
+		val, err := cache.Storage.Read(key)
+		if err == leveldb.ErrNotFound || err == ErrWrongType {
+			continue
+		}
+		if err != nil {
+			cli.WriteError(err)
+			return
+		}
+		list[i] = &val
+	}
+
+	cli.WriteArray(list)
+}
+
+//MWrite 写入多个key,请求参数为key、value、key、value...,已有的过期时间会被清除.
+func (cache *Cache) MWrite(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
 		return
 	}
 
+	if len(content)%2 != 0 {
+		cli.Write("参数错误", errcode.INFO)
+		return
+	}
+
+	for i := 0; i < len(content); i += 2 {
+		key := content[i]
+		cache.Lock.Lock(key)
+		err := cache.Storage.Write(key, content[i+1], 0)
+		cache.Lock.Unlock(key)
+		if err != nil {
+			cli.WriteError(err)
+			return
+		}
+	}
+
 	cli.Write("OK", errcode.NO_ERROR)
 }
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
+)
+
+//loadTestConf 写入临时的配置文件并加载,返回清理函数.
+func loadTestConf(t *testing.T, dir, content string) func() {
+	path := filepath.Join(dir, "server.conf")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf.Load(path)
+	return func() {
+		ioutil.WriteFile(path, nil, 0644)
+		conf.Load(path)
+	}
+}
+
+//TestNewRegistry 与proxy使用相同的注册中心,同时配置了registry_file和etcd_addr时使用静态文件.
+func TestNewRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bigcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cluster.json")
+	defer loadTestConf(t, dir, "etcd_addr = 127.0.0.1:1\nregistry_file = "+file+"\n")()
+
+	reg := newRegistry()
+	if _, ok := reg.(*registry.Static); !ok {
+		t.Fatalf("注册中心: %T", reg)
+	}
+	defer reg.Close()
+
+	cache := &Cache{Addr: "127.0.0.1:63780", Registry: reg, HeartbeatTTL: 5}
+	if err := cache.join(); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := reg.Nodes()
+	if err != nil || len(nodes) != 1 || nodes[0].ID != 1 || nodes[0].IP != cache.Addr {
+		t.Fatalf("加入集群: %+v %v", nodes, err)
+	}
+}
+
+//TestHandlerAuth 没有完成握手的请求被拒绝,密钥一致时按协商的格式处理请求.
+func TestHandlerAuth(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+	cache := &Cache{Storage: s, Lock: NewKeyLock(), Secret: "secret", MaxInflight: 1}
+
+	server, client := net.Pipe()
+	go cache.handler(cache.NewClient(server))
+	client.Write(packet.NewRequest(packet.EncodeArgs("k"), packet.READ))
+	pkt, err := packet.ParseResponse(client)
+	if err != nil || pkt.Err != errcode.AUTH_FAILED {
+		t.Fatalf("没有握手: %+v %v", pkt, err)
+	}
+	client.Close()
+
+	server, client = net.Pipe()
+	go cache.handler(cache.NewClient(server))
+	if _, err := packet.Auth(client, "wrong", packet.CAP_CHECKSUM); err == nil {
+		t.Fatal("密钥错误时握手成功")
+	}
+	client.Close()
+
+	server, client = net.Pipe()
+	defer client.Close()
+	go cache.handler(cache.NewClient(server))
+	h, err := packet.Auth(client, "secret", packet.CAP_CHECKSUM)
+	if err != nil || !h.Has(packet.CAP_CHECKSUM) {
+		t.Fatalf("握手: %+v %v", h, err)
+	}
+
+	reader := bufio.NewReader(client)
+	client.Write(h.NewRequest(1, packet.EncodeArgs("k", "v"), packet.WRITE))
+	if pkt, err := h.ParseResponse(reader); err != nil || pkt.Err != errcode.NO_ERROR {
+		t.Fatalf("写入: %+v %v", pkt, err)
+	}
+	client.Write(h.NewRequest(2, packet.EncodeArgs("k"), packet.READ))
+	if pkt, err := h.ParseResponse(reader); err != nil || pkt.ID != 2 || pkt.Msg != "v" {
+		t.Fatalf("读取: %+v %v", pkt, err)
+	}
+}
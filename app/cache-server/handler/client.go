@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"log"
 	"net"
+	"sync"
 
 	"github.com/houzhongjian/bigcache/lib/packet"
 
@@ -11,18 +13,59 @@ import (
 type Client struct {
 	Conn net.Conn
 	IP   string
+	ID   uint32      //当前处理的请求ID.
+	lock *sync.Mutex //同一个连接上的请求并发处理,写入返回数据时加锁.
+
+	Handshake packet.Handshake //握手协商的结果.
+	authed    bool
+	inflight  chan struct{} //正在并发处理的请求.
 }
 
 func (c *Cache) NewClient(conn net.Conn) *Client {
 	cli := &Client{
-		Conn: conn,
-		IP:   conn.RemoteAddr().String(),
+		Conn:     conn,
+		IP:       conn.RemoteAddr().String(),
+		lock:     &sync.Mutex{},
+		inflight: make(chan struct{}, c.MaxInflight),
 	}
 	return cli
 }
 
-//Write.
+//WithID 返回处理指定请求的客户端,与原客户端共用连接.
+func (cli *Client) WithID(id uint32) *Client {
+	c := *cli
+	c.ID = id
+	return &c
+}
+
+//Write 返回数据,超过数据包最大长度时返回错误.
 func (cli *Client) Write(msg string, num errcode.BigcacheError) {
-	buf := packet.NewResponse(msg, num)
+	if err := packet.CheckFrameSize(packet.ERRCODE_LEN + len(msg)); err != nil {
+		log.Printf("err:%+v\n", err)
+		msg, num = err.Error(), errcode.INFO
+	}
+
+	buf := cli.Handshake.NewResponse(cli.ID, msg, num)
+	cli.lock.Lock()
+	defer cli.lock.Unlock()
 	cli.Conn.Write(buf)
 }
+
+//WriteError 返回错误,同步复制超时时写入已经提交,使用单独的错误码.
+func (cli *Client) WriteError(err error) {
+	num := errcode.INFO
+	if err == ErrReplTimeout {
+		num = errcode.NOT_ACKED
+	}
+	cli.Write(err.Error(), num)
+}
+
+//WriteList 返回列表.
+func (cli *Client) WriteList(list []string) {
+	cli.Write(string(packet.EncodeArgs(list...)), errcode.NO_ERROR)
+}
+
+//WriteArray 返回可以包含nil的列表.
+func (cli *Client) WriteArray(list []*string) {
+	cli.Write(string(packet.EncodeArray(list)), errcode.NO_ERROR)
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//Incr 原子增加整数,请求参数为key和增量,返回增加之后的值.
+//key不存在时按0处理,保留原有的过期时间.
+func (cache *Cache) Incr(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	delta, err := strconv.ParseInt(content[1], 10, 64)
+	if err != nil {
+		cli.Write(errcode.MSG_NOT_INTEGER, errcode.INFO)
+		return
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	val, expireAt, err := cache.readEx(key)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	var n int64
+	if val != "" {
+		n, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			cli.Write(errcode.MSG_NOT_INTEGER, errcode.INFO)
+			return
+		}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		cli.Write(errcode.MSG_OVERFLOW, errcode.INFO)
+		return
+	}
+	n += delta
+
+	result := strconv.FormatInt(n, 10)
+	if err := cache.Storage.Write(key, result, expireAt); err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(result, errcode.NO_ERROR)
+}
+
+//IncrFloat 原子增加浮点数,请求参数为key和增量,返回增加之后的值.
+//key不存在时按0处理,保留原有的过期时间.
+func (cache *Cache) IncrFloat(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	delta, err := strconv.ParseFloat(content[1], 64)
+	if err != nil {
+		cli.Write(errcode.MSG_NOT_FLOAT, errcode.INFO)
+		return
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	val, expireAt, err := cache.readEx(key)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	var n float64
+	if val != "" {
+		n, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			cli.Write(errcode.MSG_NOT_FLOAT, errcode.INFO)
+			return
+		}
+	}
+
+	n += delta
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		cli.Write(errcode.MSG_NAN_OR_INF, errcode.INFO)
+		return
+	}
+
+	result := strconv.FormatFloat(n, 'f', -1, 64)
+	if err := cache.Storage.Write(key, result, expireAt); err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(result, errcode.NO_ERROR)
+}
+
+//readEx 读取数据及过期时间,key不存在时返回空字符串,调用方需要持有key的锁.
+func (cache *Cache) readEx(key string) (val string, expireAt int64, err error) {
+	val, err = cache.Storage.Read(key)
+	if err == leveldb.ErrNotFound {
+		return "", 0, nil
+	}
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return val, expireAt, err
+	}
+
+	expireAt, err = cache.Storage.ExpireAt(key)
+	if err == leveldb.ErrNotFound {
+		return val, 0, nil
+	}
+	return val, expireAt, err
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//call 调用请求处理函数,返回写给客户端的数据包.
+func call(t *testing.T, cache *Cache, fn func(body []byte, cli *Client), args ...string) packet.Response {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	cli := cache.NewClient(server)
+	go func() {
+		fn(packet.EncodeArgs(args...), cli)
+		server.Close()
+	}()
+
+	pkt, err := packet.ParseResponse(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+//TestIncr 不存在的key按0处理,保留原有的过期时间,溢出和非整数返回错误.
+func TestIncr(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+	cache := &Cache{Storage: s, Lock: NewKeyLock(), MaxInflight: 1}
+
+	if pkt := call(t, cache, cache.Incr, "n", "5"); pkt.Err != errcode.NO_ERROR || pkt.Msg != "5" {
+		t.Fatalf("INCRBY: %+v", pkt)
+	}
+
+	expireAt := Now() + 60000
+	if err := s.SetExpireAt("n", expireAt); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := call(t, cache, cache.Incr, "n", "-7"); pkt.Msg != "-2" {
+		t.Fatalf("DECRBY: %+v", pkt)
+	}
+	if ttl, err := s.ExpireAt("n"); err != nil || ttl != expireAt {
+		t.Fatalf("过期时间: %d %v", ttl, err)
+	}
+
+	if err := s.Write("max", "9223372036854775807", 0); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := call(t, cache, cache.Incr, "max", "1"); pkt.Msg != errcode.MSG_OVERFLOW {
+		t.Fatalf("溢出: %+v", pkt)
+	}
+
+	if err := s.Write("s", "abc", 0); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := call(t, cache, cache.Incr, "s", "1"); pkt.Msg != errcode.MSG_NOT_INTEGER {
+		t.Fatalf("非整数: %+v", pkt)
+	}
+	if pkt := call(t, cache, cache.IncrFloat, "f", "1.5"); pkt.Msg != "1.5" {
+		t.Fatalf("INCRBYFLOAT: %+v", pkt)
+	}
+}
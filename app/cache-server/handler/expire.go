@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//EXPIRE_BATCH 过期清理每批处理的数据条数.
+const EXPIRE_BATCH = 1000
+
+//expireCycle 定时清理已经过期的数据.
+//读取时也会删除过期的数据,这里负责清理过期之后不再被访问的数据.
+func (cache *Cache) expireCycle() {
+	ticker := time.NewTicker(cache.ExpireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		//从节点的过期数据由主节点清理之后同步.
+		if len(cache.Repl.Master()) > 0 {
+			continue
+		}
+
+		for {
+			list, err := cache.Storage.ExpiredKeys(Now(), EXPIRE_BATCH)
+			if err != nil {
+				break
+			}
+
+			for _, index := range list {
+				cache.Lock.Lock(index.Key)
+				err := cache.Storage.DeleteExpired(index)
+				cache.Lock.Unlock(index.Key)
+				if err != nil {
+					log.Printf("err:%+v\n", err)
+				}
+			}
+
+			if len(list) < EXPIRE_BATCH {
+				break
+			}
+		}
+	}
+}
+
+//Expire 设置过期时间,请求参数为key和过期时间(毫秒),过期时间小于等于0时删除key.
+//key存在返回1,不存在返回0.
+func (cache *Cache) Expire(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	ttl, err := strconv.ParseInt(content[1], 10, 64)
+	if err != nil {
+		cli.Write("过期时间错误", errcode.INFO)
+		return
+	}
+
+	cache.expireAt(content[0], Now()+ttl, cli)
+}
+
+//ExpireAt 设置过期时间点,请求参数为key和unix时间戳(毫秒).
+//key存在返回1,不存在返回0.
+func (cache *Cache) ExpireAt(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	expireAt, err := strconv.ParseInt(content[1], 10, 64)
+	if err != nil {
+		cli.Write("过期时间错误", errcode.INFO)
+		return
+	}
+
+	cache.expireAt(content[0], expireAt, cli)
+}
+
+func (cache *Cache) expireAt(key string, expireAt int64, cli *Client) {
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	_, err := cache.Storage.ExpireAt(key)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			cli.Write("0", errcode.NO_ERROR)
+			return
+		}
+		cli.WriteError(err)
+		return
+	}
+
+	//过期时间已经过去的直接删除.
+	if expireAt <= Now() {
+		err = cache.Storage.Delete(key)
+	} else {
+		err = cache.Storage.SetExpireAt(key, expireAt)
+	}
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write("1", errcode.NO_ERROR)
+}
+
+//TTL 获取剩余过期时间(毫秒),key不存在返回-2,永不过期返回-1.
+func (cache *Cache) TTL(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	expireAt, err := cache.Storage.ExpireAt(content[0])
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			cli.Write("-2", errcode.NO_ERROR)
+			return
+		}
+		cli.WriteError(err)
+		return
+	}
+
+	if expireAt == 0 {
+		cli.Write("-1", errcode.NO_ERROR)
+		return
+	}
+
+	ttl := expireAt - Now()
+	if ttl < 0 {
+		ttl = 0
+	}
+	cli.Write(strconv.FormatInt(ttl, 10), errcode.NO_ERROR)
+}
+
+//Persist 移除过期时间,移除成功返回1,key不存在或者没有过期时间返回0.
+func (cache *Cache) Persist(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	cache.Lock.Lock(content[0])
+	defer cache.Lock.Unlock(content[0])
+
+	expireAt, err := cache.Storage.ExpireAt(content[0])
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			cli.Write("0", errcode.NO_ERROR)
+			return
+		}
+		cli.WriteError(err)
+		return
+	}
+
+	if expireAt == 0 {
+		cli.Write("0", errcode.NO_ERROR)
+		return
+	}
+
+	if err := cache.Storage.SetExpireAt(content[0], 0); err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write("1", errcode.NO_ERROR)
+}
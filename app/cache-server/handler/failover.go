@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/etcd"
+)
+
+//ErrNotLeader 其他从节点已经成为新的主节点.
+var ErrNotLeader = errors.New("其他从节点已经成为新的主节点")
+
+//nodeWatch 监听etcd中当前节点的信息,主节点发生变化时切换主节点.
+func (cache *Cache) nodeWatch(rev int64) {
+	for {
+		rch := cache.Etcd.Watch(context.Background(), "/cacheserver/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for wresp := range rch {
+			if err := wresp.Err(); err != nil {
+				log.Printf("err:%+v\n", err)
+				var master string
+				master, rev = cache.loadMaster()
+				if err := cache.setMaster(master); err != nil {
+					log.Printf("err:%+v\n", err)
+				}
+				break
+			}
+			rev = wresp.Header.Revision
+
+			for _, ev := range wresp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+
+				cacheServer := base.CacheServer{}
+				if err := json.Unmarshal(ev.Kv.Value, &cacheServer); err != nil {
+					log.Printf("err:%+v\n", err)
+					continue
+				}
+				if cacheServer.IP != cache.Addr {
+					continue
+				}
+				if err := cache.setMaster(cacheServer.Master); err != nil {
+					log.Printf("err:%+v\n", err)
+				}
+			}
+		}
+	}
+}
+
+//checkMaster 从节点定时检查主节点的状态.
+//主节点在etcd中的存活状态已经过期,并且复制连接的心跳也已经中断时,认为主节点下线并参与选举.
+//启动之后没有完成过同步,或者全量同步没有完成的从节点数据不完整,不参与选举.
+func (cache *Cache) checkMaster() {
+	ticker := time.NewTicker(REPL_PING_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		master, linked := cache.Repl.Linked()
+		if len(master) < 1 || linked || !cache.Repl.Synced() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		response, err := cache.Etcd.Get(ctx, etcd.ALIVE_PREFIX+master, clientv3.WithCountOnly())
+		cancel()
+		if err != nil || response.Count > 0 {
+			continue
+		}
+
+		if err := cache.failover(master); err != nil {
+			log.Println("选举失败:", master, err)
+		}
+	}
+}
+
+//failover 参与主节点的选举.
+//从节点将自己的复制序号写入etcd,等待其他从节点参与之后,序号最大的从节点成为新的主节点,序号相同时ip较小的优先.
+func (cache *Cache) failover(master string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cache.FailoverWait+10*time.Second)
+	defer cancel()
+
+	lease, err := cache.Etcd.Grant(ctx, cache.HeartbeatTTL)
+	if err != nil {
+		return err
+	}
+	//选举结束之后删除参与选举的信息.
+	defer cache.Etcd.Revoke(context.Background(), lease.ID)
+
+	prefix := etcd.FAILOVER_PREFIX + master + "/candidate/"
+	_, seq := cache.Storage.ReplState()
+	if _, err := cache.Etcd.Put(ctx, prefix+cache.Addr, strconv.FormatUint(seq, 10), clientv3.WithLease(lease.ID)); err != nil {
+		return err
+	}
+	log.Println("主节点下线, 参与选举:", master, "序号:", seq)
+
+	time.Sleep(cache.FailoverWait)
+
+	response, err := cache.Etcd.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	var best string
+	var bestSeq uint64
+	for _, v := range response.Kvs {
+		addr := string(v.Key[len(prefix):])
+		n, err := strconv.ParseUint(string(v.Value), 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(best) < 1 || n > bestSeq || (n == bestSeq && addr < best) {
+			best, bestSeq = addr, n
+		}
+	}
+	if best != cache.Addr {
+		log.Println("选举结果:", best, "序号:", bestSeq)
+		return nil
+	}
+
+	return cache.promote(ctx, master, lease.ID)
+}
+
+//promote 成为新的主节点.
+//第一个事务写入选举结果,同时将原主节点及其他从节点改为同步当前节点,事务中比较每个key的版本号,期间被其他人修改时放弃.
+//之后原主节点的插槽指向当前节点,插槽数量超过etcd单个事务的最大操作数时分为多个事务,不是原子的,失败时重新读取插槽并重试,直到全部完成.
+//分批修改期间proxy根据第一个事务中写入的节点信息,将仍然指向原主节点的插槽路由到当前节点.
+//完成之前保持选举的租约,其他从节点已经改为同步当前节点,不会再次参与原主节点的选举.
+func (cache *Cache) promote(ctx context.Context, master string, lease clientv3.LeaseID) error {
+	servers, err := cache.Etcd.Get(ctx, "/cacheserver/", clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	var list []base.CacheServer
+	for _, v := range servers.Kvs {
+		cacheServer := base.CacheServer{}
+		if err := json.Unmarshal(v.Value, &cacheServer); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+
+		switch {
+		case cacheServer.IP == cache.Addr:
+			//其他节点已经完成切换,当前节点不再是原主节点的从节点.
+			if cacheServer.Master != master {
+				return ErrNotLeader
+			}
+			cacheServer.Master = ""
+		case cacheServer.IP == master || cacheServer.Master == master:
+			cacheServer.Master = cache.Addr
+		default:
+			list = append(list, cacheServer)
+			continue
+		}
+		list = append(list, cacheServer)
+
+		b, err := json.Marshal(cacheServer)
+		if err != nil {
+			return err
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(v.Key)), "=", v.ModRevision))
+		ops = append(ops, clientv3.OpPut(string(v.Key), string(b)))
+	}
+
+	leader := etcd.FAILOVER_PREFIX + master + "/leader"
+	guard := clientv3.Compare(clientv3.CreateRevision(leader), "=", 0)
+	claim := clientv3.OpPut(leader, cache.Addr, clientv3.WithLease(lease))
+	resp, err := cache.Etcd.Txn(ctx).
+		If(append([]clientv3.Cmp{guard}, cmps...)...).
+		Then(append([]clientv3.Op{claim}, ops...)...).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrNotLeader
+	}
+
+	//选举成功之后立即开始接受写入.
+	if err := cache.setMaster(""); err != nil {
+		return err
+	}
+	log.Println("成为新的主节点, 原主节点:", master)
+
+	//插槽切换完成之前保持选举的租约.
+	keep, stop := context.WithCancel(context.Background())
+	defer stop()
+	if ch, err := cache.Etcd.KeepAlive(keep, lease); err != nil {
+		log.Printf("err:%+v\n", err)
+	} else {
+		go func() {
+			for range ch {
+			}
+		}()
+	}
+
+	n := cache.switchSlots(master, base.ReplicasOf(list, cache.Addr))
+	log.Println("插槽切换完成, 原主节点:", master, "修改:", n)
+	return nil
+}
+
+//switchSlots 将原主节点的插槽指向当前节点,返回修改的插槽数量.
+//每次重新读取仍然指向原主节点的插槽,按etcd单个事务的最大操作数分批修改,失败时重试,直到没有指向原主节点的插槽.
+func (cache *Cache) switchSlots(master string, replicas []string) (total int) {
+	for {
+		n, remain, err := cache.switchSlotsOnce(master, replicas)
+		total += n
+		if err == nil && remain == 0 {
+			return total
+		}
+		if err != nil {
+			log.Println("插槽切换失败, 稍后重试:", master, err)
+		}
+		time.Sleep(REPL_RETRY_INTERVAL)
+	}
+}
+
+//switchSlotsOnce 读取指向原主节点的插槽并分批修改,返回修改成功的数量和没有修改成功的数量.
+//事务中比较每个插槽的版本号,期间被其他人修改的插槽在下一次重新读取.
+func (cache *Cache) switchSlotsOnce(master string, replicas []string) (n, remain int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slots, err := cache.Etcd.Get(ctx, "/slot/", clientv3.WithPrefix())
+	if err != nil {
+		return n, remain, err
+	}
+
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for _, v := range slots.Kvs {
+		slot := base.Slot{}
+		if err := json.Unmarshal(v.Value, &slot); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+		if slot.IP != master && slot.NewIP != master {
+			continue
+		}
+
+		if slot.IP == master {
+			slot.IP = cache.Addr
+			slot.Replicas = replicas
+		}
+		if slot.NewIP == master {
+			slot.NewIP = cache.Addr
+		}
+
+		b, err := json.Marshal(slot)
+		if err != nil {
+			return n, remain, err
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(v.Key)), "=", v.ModRevision))
+		ops = append(ops, clientv3.OpPut(string(v.Key), string(b)))
+	}
+
+	for i := 0; i < len(ops); i += cache.MaxTxnOps {
+		end := i + cache.MaxTxnOps
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		resp, err := cache.Etcd.Txn(ctx).If(cmps[i:end]...).Then(ops[i:end]...).Commit()
+		if err != nil {
+			return n, len(ops) - n, err
+		}
+		if !resp.Succeeded {
+			log.Println("插槽切换冲突, 稍后重试:", master, "数量:", end-i)
+			continue
+		}
+		n += end - i
+	}
+	return n, len(ops) - n, nil
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/registry"
+)
+
+//join 将当前节点写入注册中心(静态文件或gossip),并通过租约保持存活状态.
+//没有etcd的事务,多个节点同时自动分配编号时可能冲突,这两种模式下建议配置server_id.
+func (cache *Cache) join() error {
+	list, err := cache.Registry.Nodes()
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	var maxID uint
+	cacheServer := base.CacheServer{}
+	for _, record := range list {
+		if record.ID > maxID {
+			maxID = record.ID
+		}
+		if (cache.ID > 0 && record.ID == cache.ID) || (cache.ID == 0 && record.IP == cache.Addr) {
+			found, cacheServer = true, record
+		}
+	}
+
+	if !found {
+		id := cache.ID
+		if id == 0 {
+			id = maxID + 1
+		}
+		cacheServer = base.CacheServer{ID: id, IP: cache.Addr, Master: conf.GetString("replica_of")}
+	}
+	if cacheServer.IP != cache.Addr {
+		return fmt.Errorf("%w: %d %s", ErrServerID, cacheServer.ID, cacheServer.IP)
+	}
+
+	if cacheServer.Types != base.CACHESERVER_TYPE_MIGRATE {
+		cacheServer.Types = base.CACHESERVER_TYPE_NORMAL
+	}
+	cacheServer.Capacity = cache.Capacity
+	cacheServer.Version = base.VERSION
+	if err := cache.Registry.PutNode(cacheServer); err != nil {
+		return err
+	}
+	cache.ID = cacheServer.ID
+
+	b, err := json.Marshal(cache.alive())
+	if err != nil {
+		return err
+	}
+	if _, err := cache.Registry.Grant(registry.LEASE_NODE, cache.Addr, string(b), cache.HeartbeatTTL); err != nil {
+		return err
+	}
+
+	log.Println("加入集群, 节点编号:", cache.ID)
+	return nil
+}
+
+//gossipWatch 监听gossip集群中当前节点的信息,主节点发生变化时切换主节点.
+func (cache *Cache) gossipWatch() {
+	for ev := range cache.Registry.WatchNodes() {
+		if ev.Type != registry.EVENT_PUT || ev.Node.IP != cache.Addr {
+			continue
+		}
+		if err := cache.setMaster(ev.Node.Master); err != nil {
+			log.Printf("err:%+v\n", err)
+		}
+	}
+}
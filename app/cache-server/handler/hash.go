@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//HSet 写入hash的field,请求参数为key、field、value、field、value...,返回新增的field个数.
+func (cache *Cache) HSet(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	if len(content)%2 == 0 {
+		cli.Write("参数错误", errcode.INFO)
+		return
+	}
+
+	key := content[0]
+	fields := make([]string, 0, len(content)/2)
+	values := make([]string, 0, len(content)/2)
+	for i := 1; i < len(content); i += 2 {
+		fields = append(fields, content[i])
+		values = append(values, content[i+1])
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.HSet(key, fields, values)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//HGet 读取hash的field,请求参数为key、field.
+func (cache *Cache) HGet(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	val, err := cache.Storage.HGet(content[0], content[1])
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			cli.Write(err.Error(), errcode.NOT_FOUND)
+			return
+		}
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(val, errcode.NO_ERROR)
+}
+
+//HMGet 读取hash的多个field,请求参数为key、field...,返回列表,不存在的field为nil.
+func (cache *Cache) HMGet(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	list, err := cache.Storage.HMGet(content[0], content[1:])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteArray(list)
+}
+
+//HGetAll 读取hash的所有field,返回列表,field和value交替排列.
+func (cache *Cache) HGetAll(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	list, err := cache.Storage.HGetAll(content[0])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(list)
+}
+
+//HDel 删除hash的field,请求参数为key、field...,返回删除的field个数.
+func (cache *Cache) HDel(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.HDel(key, content[1:])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//HIncrBy 原子增加hash的field,请求参数为key、field、增量,返回增加之后的值.
+//field不存在时按0处理.
+func (cache *Cache) HIncrBy(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	field := content[1]
+	delta, err := strconv.ParseInt(content[2], 10, 64)
+	if err != nil {
+		cli.Write(errcode.MSG_NOT_INTEGER, errcode.INFO)
+		return
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	var n int64
+	val, err := cache.Storage.HGet(key, field)
+	if err != nil && err != leveldb.ErrNotFound {
+		cli.WriteError(err)
+		return
+	}
+	if err == nil {
+		n, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			cli.Write(errcode.MSG_HASH_NOT_INTEGER, errcode.INFO)
+			return
+		}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		cli.Write(errcode.MSG_OVERFLOW, errcode.INFO)
+		return
+	}
+	n += delta
+
+	result := strconv.FormatInt(n, 10)
+	if _, err := cache.Storage.HSet(key, []string{field}, []string{result}); err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(result, errcode.NO_ERROR)
+}
+
+//HLen 获取hash的field个数.
+func (cache *Cache) HLen(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	n, err := cache.Storage.HLen(content[0])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
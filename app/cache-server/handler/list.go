@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//Push 插入list元素,请求参数为key、value...,left为true时从头部插入,返回插入之后的元素个数.
+func (cache *Cache) Push(body []byte, cli *Client, left bool) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.LPush(key, content[1:], left)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//Pop 弹出list元素,请求参数为key、数量,left为true时从头部弹出,返回列表.
+func (cache *Cache) Pop(body []byte, cli *Client, left bool) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	count, err := strconv.Atoi(content[1])
+	if err != nil || count < 0 {
+		cli.Write("数量错误", errcode.INFO)
+		return
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	list, err := cache.Storage.LPop(key, count, left)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(list)
+}
+
+//LRange 获取list指定区间的元素,请求参数为key、start、stop,返回列表.
+func (cache *Cache) LRange(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	start, stop, ok := cache.indexArgs(content[1:], cli)
+	if !ok {
+		return
+	}
+
+	list, err := cache.Storage.LRange(content[0], start, stop)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(list)
+}
+
+//LLen 获取list的元素个数.
+func (cache *Cache) LLen(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	n, err := cache.Storage.LLen(content[0])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//LTrim 只保留list指定区间的元素,请求参数为key、start、stop.
+func (cache *Cache) LTrim(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	start, stop, ok := cache.indexArgs(content[1:], cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	if err := cache.Storage.LTrim(key, start, stop); err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write("OK", errcode.NO_ERROR)
+}
+
+//indexArgs 解析start、stop参数.
+func (cache *Cache) indexArgs(content []string, cli *Client) (start, stop int, ok bool) {
+	start, err := strconv.Atoi(content[0])
+	if err != nil {
+		cli.Write(errcode.MSG_NOT_INTEGER, errcode.INFO)
+		return
+	}
+
+	stop, err = strconv.Atoi(content[1])
+	if err != nil {
+		cli.Write(errcode.MSG_NOT_INTEGER, errcode.INFO)
+		return
+	}
+
+	return start, stop, true
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//LOCK_COUNT 分段锁的数量.
+const LOCK_COUNT = 1024
+
+//KeyLock key级别的分段锁,读-改-写操作需要持有对应key的锁.
+type KeyLock struct {
+	locks [LOCK_COUNT]sync.Mutex
+}
+
+func NewKeyLock() *KeyLock {
+	return &KeyLock{}
+}
+
+//Lock 锁定key.
+func (l *KeyLock) Lock(key string) {
+	l.locks[utils.CRC32(key)%LOCK_COUNT].Lock()
+}
+
+//Unlock 解锁key.
+func (l *KeyLock) Unlock(key string) {
+	l.locks[utils.CRC32(key)%LOCK_COUNT].Unlock()
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//Dump 序列化一条记录,用于迁移.
+func (cache *Cache) Dump(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	data, err := cache.Storage.Dump(content[0])
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			cli.Write(err.Error(), errcode.NOT_FOUND)
+			return
+		}
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(data, errcode.NO_ERROR)
+}
+
+//MigrateWrite 迁移写入操作,请求参数为key和Dump序列化的数据.
+//迁移过程中新数据直接写入目标节点,因此目标节点已存在的key不能被旧数据覆盖.
+func (cache *Cache) MigrateWrite(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	data := content[1]
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	_, err := cache.Storage.ExpireAt(key)
+	if err == nil {
+		cli.Write("EXISTS", errcode.NO_ERROR)
+		return
+	}
+	if err != leveldb.ErrNotFound {
+		cli.WriteError(err)
+		return
+	}
+
+	if err := cache.Storage.Restore(key, data); err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write("OK", errcode.NO_ERROR)
+}
+
+//Migrate 将插槽中的数据推送到目标节点.
+//推送成功的数据会从当前节点删除,返回迁移的数据条数.
+func (cache *Cache) Migrate(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	slotid, err := strconv.Atoi(content[0])
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+	targetIP := content[1]
+
+	//插槽迁移使用单独的连接,不影响proxy触发的单个key迁移.
+	target := &MigrateTarget{addr: targetIP, lock: &sync.Mutex{}}
+	defer target.close()
+
+	total := 0
+	var migrateErr error
+	err = cache.Storage.SlotRange(uint32(slotid), "", func(key string) bool {
+		if migrateErr = cache.migrateKey(target, key); migrateErr != nil {
+			return false
+		}
+
+		total++
+		return true
+	})
+	if err == nil {
+		err = migrateErr
+	}
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	log.Println("插槽:", slotid, "迁移到", targetIP, "完成, 共", total, "条数据")
+	cli.Write(strconv.Itoa(total), errcode.NO_ERROR)
+}
+
+//MigrateKey 将一个key迁移到目标节点,请求参数为key和目标节点的ip.
+//插槽迁移期间proxy访问key之前调用,读取、写入目标节点及删除本地数据期间持有key的锁,
+//与当前节点的其他写入以及插槽迁移互斥,不会把旧数据写入目标节点,也不会删除读取之后写入的数据.
+func (cache *Cache) MigrateKey(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	target := cache.Targets.get(content[1])
+	target.lock.Lock()
+	defer target.lock.Unlock()
+
+	if err := cache.migrateKey(target, key); err != nil {
+		//连接可能已经不可用,下次重新连接.
+		target.close()
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write("OK", errcode.NO_ERROR)
+}
+
+//migrateKey 将一条数据写入目标节点,成功后删除本地数据,target需要持有锁.
+func (cache *Cache) migrateKey(target *MigrateTarget, key string) error {
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	data, err := cache.Storage.Dump(key)
+	if err != nil {
+		//遍历之后过期或被删除的数据不需要迁移.
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	conn, h, err := target.connect(cache.Secret)
+	if err != nil {
+		return err
+	}
+
+	b := packet.EncodeArgs(key, data)
+	if err := packet.CheckFrameSize(len(b)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(h.NewRequest(0, b, packet.MIGRATE_WRITE)); err != nil {
+		return err
+	}
+
+	pkt, err := h.ParseResponse(conn)
+	if err != nil {
+		return err
+	}
+
+	if pkt.Err != errcode.NO_ERROR {
+		return errors.New(pkt.Msg)
+	}
+
+	return cache.Storage.Delete(key)
+}
+
+//MigrateTargets 迁移单个key时与目标节点的连接,每个目标节点一个连接.
+type MigrateTargets struct {
+	lock    *sync.Mutex
+	targets map[string]*MigrateTarget
+}
+
+func NewMigrateTargets() *MigrateTargets {
+	return &MigrateTargets{
+		lock:    &sync.Mutex{},
+		targets: make(map[string]*MigrateTarget),
+	}
+}
+
+//get 获取目标节点的连接,不存在时创建,连接在第一次使用时建立.
+func (t *MigrateTargets) get(addr string) *MigrateTarget {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	target, ok := t.targets[addr]
+	if !ok {
+		target = &MigrateTarget{addr: addr, lock: &sync.Mutex{}}
+		t.targets[addr] = target
+	}
+	return target
+}
+
+//MigrateTarget 与目标节点的连接,同一个连接上的请求需要持有锁依次发送.
+type MigrateTarget struct {
+	addr string
+	lock *sync.Mutex
+	conn net.Conn
+	h    packet.Handshake
+}
+
+//connect 返回与目标节点的连接,没有连接时建立连接并握手.
+func (t *MigrateTarget) connect(secret string) (net.Conn, packet.Handshake, error) {
+	if t.conn != nil {
+		return t.conn, t.h, nil
+	}
+
+	conn, err := net.DialTimeout("tcp4", t.addr, time.Second*5)
+	if err != nil {
+		return nil, t.h, err
+	}
+	h, err := packet.Auth(conn, secret, packet.CAP_CHECKSUM)
+	if err != nil {
+		conn.Close()
+		return nil, t.h, err
+	}
+
+	t.conn, t.h = conn, h
+	return conn, h, nil
+}
+
+//close 关闭连接.
+func (t *MigrateTarget) close() {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+//SlotKeys 分页获取插槽中的key.
+//请求参数为插槽号、游标(上一页最后一个key)、数量,返回key列表,返回数量小于请求数量时表示遍历结束.
+func (cache *Cache) SlotKeys(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	slotid, err := strconv.Atoi(content[0])
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+	cursor := content[1]
+	count, err := strconv.Atoi(content[2])
+	if err != nil || count < 1 {
+		cli.Write("数量错误", errcode.INFO)
+		return
+	}
+
+	keys := []string{}
+	err = cache.Storage.SlotRange(uint32(slotid), cursor, func(key string) bool {
+		//游标本身在上一页已经返回.
+		if cursor != "" && key == cursor {
+			return true
+		}
+		keys = append(keys, key)
+		return len(keys) < count
+	})
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(keys)
+}
+
+//SlotCount 获取插槽中的数据条数.
+func (cache *Cache) SlotCount(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	slotid, err := strconv.Atoi(content[0])
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	total, err := cache.Storage.SlotCount(uint32(slotid))
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(total), errcode.NO_ERROR)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+//TestMigrateWrite 迁移的数据包含复合类型的元素及过期时间,目标节点已经存在的key不会被覆盖.
+func TestMigrateWrite(t *testing.T) {
+	src, done := newTestStorage(t)
+	defer done()
+	dst, done := newTestStorage(t)
+	defer done()
+	cache := &Cache{Storage: dst, Lock: NewKeyLock(), MaxInflight: 1}
+
+	expireAt := Now() + 60000
+	if _, err := src.HSet("h", []string{"a", "b"}, []string{"1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetExpireAt("h", expireAt); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.Dump("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt := call(t, cache, cache.MigrateWrite, "h", data); pkt.Msg != "OK" {
+		t.Fatalf("迁移写入: %+v", pkt)
+	}
+	list, err := dst.HGetAll("h")
+	if err != nil || !reflect.DeepEqual(list, []string{"a", "1", "b", "2"}) {
+		t.Fatalf("迁移之后的数据: %v %v", list, err)
+	}
+	if ttl, err := dst.ExpireAt("h"); err != nil || ttl != expireAt {
+		t.Fatalf("过期时间: %d %v", ttl, err)
+	}
+
+	//迁移过程中写入目标节点的新数据不会被旧数据覆盖.
+	if err := dst.Write("s", "new", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Write("s", "old", 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = src.Dump("s"); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := call(t, cache, cache.MigrateWrite, "s", data); pkt.Msg != "EXISTS" {
+		t.Fatalf("目标节点已存在: %+v", pkt)
+	}
+	if val, err := dst.Read("s"); err != nil || val != "new" {
+		t.Fatalf("读取: %q %v", val, err)
+	}
+}
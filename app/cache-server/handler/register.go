@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/etcd"
+)
+
+//ErrServerID 节点编号已经被其他节点使用.
+var ErrServerID = errors.New("节点编号已被其他节点使用")
+
+//register 将当前节点注册到etcd.
+//节点信息已经存在时只更新ip、容量和版本号,保留主节点等由admin或故障切换写入的信息.
+//未配置节点编号时使用etcd中相同ip的节点编号,都没有时分配新的编号.
+//节点信息/cacheserver/<id>不绑定租约,会一直保留,插槽、主从关系及故障切换都依赖节点信息,节点下线时不能被删除.
+//只有heartbeat写入的/alive/<ip>绑定租约,租约过期之后admin根据节点信息和存活状态显示为离线.
+func (cache *Cache) register() error {
+	for {
+		ok, err := cache.tryRegister()
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Println("节点注册成功, 编号:", cache.ID)
+			return nil
+		}
+		//注册期间节点信息被修改,重新读取.
+	}
+}
+
+//tryRegister 读取节点信息并在etcd事务中写入,期间被其他人修改时返回false.
+func (cache *Cache) tryRegister() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := cache.Etcd.Get(ctx, "/cacheserver/", clientv3.WithPrefix())
+	if err != nil {
+		return false, err
+	}
+
+	var key string
+	var rev int64
+	var maxID uint
+	cacheServer := base.CacheServer{}
+	for _, v := range response.Kvs {
+		record := base.CacheServer{}
+		if err := json.Unmarshal(v.Value, &record); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+		if record.ID > maxID {
+			maxID = record.ID
+		}
+
+		if (cache.ID > 0 && record.ID == cache.ID) || (cache.ID == 0 && record.IP == cache.Addr) {
+			key, rev, cacheServer = string(v.Key), v.ModRevision, record
+		}
+	}
+
+	if len(key) < 1 {
+		id := cache.ID
+		if id == 0 {
+			id = maxID + 1
+		}
+		key = fmt.Sprintf("/cacheserver/%d", id)
+		cacheServer = base.CacheServer{ID: id, IP: cache.Addr, Master: conf.GetString("replica_of")}
+	}
+	if cacheServer.IP != cache.Addr {
+		return false, fmt.Errorf("%w: %d %s", ErrServerID, cacheServer.ID, cacheServer.IP)
+	}
+
+	if cacheServer.Types != base.CACHESERVER_TYPE_MIGRATE {
+		cacheServer.Types = base.CACHESERVER_TYPE_NORMAL
+	}
+	cacheServer.Capacity = cache.Capacity
+	cacheServer.Version = base.VERSION
+	b, err := json.Marshal(cacheServer)
+	if err != nil {
+		return false, err
+	}
+
+	//节点信息不存在时ModRevision为0.
+	resp, err := cache.Etcd.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpPut(key, string(b))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		return false, nil
+	}
+
+	cache.ID = cacheServer.ID
+	return true, nil
+}
+
+//heartbeat 在etcd中保持当前节点的存活状态,即/alive/<ip>.
+//存活状态绑定租约,节点停止心跳超过租约的过期时间之后被删除,admin中显示为离线,从节点开始选举.
+func (cache *Cache) heartbeat() {
+	for {
+		err := cache.keepAlive()
+		log.Println("心跳中断:", err)
+		time.Sleep(time.Second)
+	}
+}
+
+//keepAlive 创建租约并写入存活状态,租约过期或者连接中断时返回.
+func (cache *Cache) keepAlive() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := json.Marshal(cache.alive())
+	if err != nil {
+		return err
+	}
+
+	lease, err := cache.Etcd.Grant(ctx, cache.HeartbeatTTL)
+	if err != nil {
+		return err
+	}
+	if _, err := cache.Etcd.Put(ctx, etcd.ALIVE_PREFIX+cache.Addr, string(b), clientv3.WithLease(lease.ID)); err != nil {
+		return err
+	}
+
+	ch, err := cache.Etcd.KeepAlive(context.Background(), lease.ID)
+	if err != nil {
+		return err
+	}
+	for range ch {
+	}
+	return errors.New("租约已过期")
+}
+
+//alive 存活状态的内容,包括节点的编号、ip、容量和版本号.
+func (cache *Cache) alive() base.CacheServer {
+	return base.CacheServer{
+		ID:       cache.ID,
+		Types:    base.CACHESERVER_TYPE_NORMAL,
+		IP:       cache.Addr,
+		Capacity: cache.Capacity,
+		Version:  base.VERSION,
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//DEFAULT_REPL_LOG_SIZE 复制日志默认保留的最大字节数.
+const DEFAULT_REPL_LOG_SIZE = 64 << 20
+
+//REPL_READ_BATCH 每次读取的最大日志条数.
+const REPL_READ_BATCH = 1000
+
+var ErrLogTruncated = errors.New("复制日志已被清理,需要全量同步")
+
+//ErrReplTimeout 等待从节点确认超时,写入已经在主节点提交,返回NOT_ACKED.
+var ErrReplTimeout = errors.New(errcode.MSG_NOT_ACKED)
+
+//ReplEntry 一条复制日志,内容为一次写入的数据.
+type ReplEntry struct {
+	Seq  uint64
+	Data []byte
+}
+
+//ReplicaState 从节点的同步状态.
+type ReplicaState struct {
+	Addr string
+	Seq  uint64    //从节点确认的序号.
+	Time time.Time //最近一次确认的时间.
+}
+
+//ReplLog 内存中的复制日志,只保留最近写入的数据.
+//从节点落后的日志已经被清理时需要全量同步.
+type ReplLog struct {
+	lock     *sync.Mutex
+	entries  []ReplEntry
+	size     int
+	limit    int
+	last     uint64        //最新的序号.
+	notify   chan struct{} //写入日志或从节点确认时关闭,用于唤醒等待者.
+	replicas map[string]*ReplicaState
+
+	SyncReplicas int           //写入时需要等待确认的从节点数量,0表示异步复制.
+	SyncTimeout  time.Duration //等待从节点确认的超时时间.
+}
+
+//NewReplLog 创建复制日志,limit为保留的最大字节数.
+func NewReplLog(limit int) *ReplLog {
+	if limit <= 0 {
+		limit = DEFAULT_REPL_LOG_SIZE
+	}
+	return &ReplLog{
+		lock:     &sync.Mutex{},
+		limit:    limit,
+		notify:   make(chan struct{}),
+		replicas: make(map[string]*ReplicaState),
+	}
+}
+
+//wake 唤醒所有等待者,调用时需要持有锁.
+func (l *ReplLog) wake() {
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+//Append 追加一条日志,超过最大字节数时清理最早的日志,至少保留最新的一条.
+func (l *ReplLog) Append(seq uint64, data []byte) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries = append(l.entries, ReplEntry{Seq: seq, Data: data})
+	l.size += len(data)
+	l.last = seq
+	for l.size > l.limit && len(l.entries) > 1 {
+		l.size -= len(l.entries[0].Data)
+		l.entries[0] = ReplEntry{}
+		l.entries = l.entries[1:]
+	}
+	l.wake()
+}
+
+//Reset 清空日志,之后的日志从seq之后开始.
+func (l *ReplLog) Reset(seq uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries = nil
+	l.size = 0
+	l.last = seq
+	l.wake()
+}
+
+//first 最早一条日志的序号,调用时需要持有锁.
+func (l *ReplLog) first() uint64 {
+	if len(l.entries) == 0 {
+		return l.last + 1
+	}
+	return l.entries[0].Seq
+}
+
+//Has 是否可以从seq之后开始增量同步.
+func (l *ReplLog) Has(seq uint64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return seq+1 >= l.first() && seq <= l.last
+}
+
+//Read 读取seq之后的日志,没有新的日志时最多等待timeout,超时返回空.
+func (l *ReplLog) Read(seq uint64, timeout time.Duration) ([]ReplEntry, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		l.lock.Lock()
+		if seq+1 < l.first() || seq > l.last {
+			l.lock.Unlock()
+			return nil, ErrLogTruncated
+		}
+		if seq < l.last {
+			start := int(seq + 1 - l.first())
+			end := len(l.entries)
+			if end-start > REPL_READ_BATCH {
+				end = start + REPL_READ_BATCH
+			}
+			list := append([]ReplEntry{}, l.entries[start:end]...)
+			l.lock.Unlock()
+			return list, nil
+		}
+		notify := l.notify
+		l.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, nil
+		}
+	}
+}
+
+//Last 最新的序号.
+func (l *ReplLog) Last() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.last
+}
+
+//AddReplica 记录连接的从节点.
+func (l *ReplLog) AddReplica(addr string, seq uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.replicas[addr] = &ReplicaState{Addr: addr, Seq: seq, Time: time.Now()}
+}
+
+//RemoveReplica 从节点断开连接.
+func (l *ReplLog) RemoveReplica(addr string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.replicas, addr)
+	l.wake()
+}
+
+//Ack 从节点确认已经写入的序号.
+func (l *ReplLog) Ack(addr string, seq uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	replica, ok := l.replicas[addr]
+	if !ok {
+		return
+	}
+	replica.Seq = seq
+	replica.Time = time.Now()
+	l.wake()
+}
+
+//Replicas 获取所有连接的从节点.
+func (l *ReplLog) Replicas() []ReplicaState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	list := make([]ReplicaState, 0, len(l.replicas))
+	for _, replica := range l.replicas {
+		list = append(list, *replica)
+	}
+	return list
+}
+
+//WaitAck 同步复制时等待足够数量的从节点确认seq,异步复制时直接返回.
+//调用时seq对应的写入已经提交并追加到复制日志,超时只表示从节点还没有确认.
+func (l *ReplLog) WaitAck(seq uint64) error {
+	if l.SyncReplicas < 1 {
+		return nil
+	}
+
+	timer := time.NewTimer(l.SyncTimeout)
+	defer timer.Stop()
+
+	for {
+		l.lock.Lock()
+		n := 0
+		for _, replica := range l.replicas {
+			if replica.Seq >= seq {
+				n++
+			}
+		}
+		notify := l.notify
+		l.lock.Unlock()
+
+		if n >= l.SyncReplicas {
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return ErrReplTimeout
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//REPL_PING_INTERVAL 没有新的复制日志时主节点发送心跳的间隔.
+const REPL_PING_INTERVAL = time.Second
+
+//REPL_TIMEOUT 超过该时间没有收到数据时认为复制连接已经断开.
+const REPL_TIMEOUT = REPL_PING_INTERVAL * 5
+
+//REPL_RETRY_INTERVAL 同步失败后重新连接主节点的间隔.
+const REPL_RETRY_INTERVAL = time.Second
+
+//同步方式.
+const (
+	REPL_MODE_FULL    = "FULL"    //全量同步,先推送快照再推送之后的复制日志.
+	REPL_MODE_PARTIAL = "PARTIAL" //增量同步,从从节点的序号之后推送复制日志.
+)
+
+//Replication 当前节点的主从状态.
+type Replication struct {
+	lock      *sync.Mutex
+	master    string        //主节点ip,为空时为主节点.
+	stop      chan struct{} //关闭时停止同步当前主节点.
+	linked    bool          //是否已经与主节点完成同步.
+	synced    bool          //启动之后是否完成过同步,数据不完整时不参与选举.
+	masterSeq uint64        //主节点最新的序号.
+	contact   time.Time     //最近一次收到主节点数据的时间.
+}
+
+//newReplLog 从配置文件中读取复制日志的配置,时间单位为毫秒.
+func newReplLog() *ReplLog {
+	l := NewReplLog(conf.GetInt("repl_log_size"))
+	l.SyncReplicas = conf.GetInt("repl_sync_replicas")
+	l.SyncTimeout = time.Duration(conf.GetInt("repl_sync_timeout")) * time.Millisecond
+	if l.SyncTimeout <= 0 {
+		l.SyncTimeout = time.Second
+	}
+	return l
+}
+
+//Master 获取主节点ip,为空时为主节点.
+func (repl *Replication) Master() string {
+	repl.lock.Lock()
+	defer repl.lock.Unlock()
+	return repl.master
+}
+
+//Linked 获取主节点ip及是否与主节点保持连接.
+func (repl *Replication) Linked() (master string, linked bool) {
+	repl.lock.Lock()
+	defer repl.lock.Unlock()
+	return repl.master, repl.linked
+}
+
+//Synced 启动之后是否完成过同步,全量同步开始之后到完成之前为false.
+func (repl *Replication) Synced() bool {
+	repl.lock.Lock()
+	defer repl.lock.Unlock()
+	return repl.synced
+}
+
+//touch 收到主节点的数据.
+func (repl *Replication) touch(masterSeq uint64, linked bool) {
+	repl.lock.Lock()
+	defer repl.lock.Unlock()
+	if masterSeq > repl.masterSeq {
+		repl.masterSeq = masterSeq
+	}
+	repl.linked = linked
+	if linked {
+		repl.synced = true
+	}
+	repl.contact = time.Now()
+}
+
+//unsync 全量同步开始时清空了数据,完成之前不参与选举.
+func (repl *Replication) unsync() {
+	repl.lock.Lock()
+	defer repl.lock.Unlock()
+	repl.synced = false
+}
+
+//loadMaster 加载当前节点的主节点,配置了etcd时以etcd中的节点信息为准,同时返回etcd的版本号.
+//使用静态文件或gossip时以注册中心中的节点信息为准.
+func (cache *Cache) loadMaster() (master string, rev int64) {
+	master = conf.GetString("replica_of")
+	if cache.Registry != nil {
+		list, err := cache.Registry.Nodes()
+		if err != nil {
+			log.Printf("err:%+v\n", err)
+			return master, rev
+		}
+		for _, cacheServer := range list {
+			if cacheServer.IP == cache.Addr {
+				return cacheServer.Master, rev
+			}
+		}
+		return master, rev
+	}
+	if cache.Etcd == nil {
+		return master, rev
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := cache.Etcd.Get(ctx, "/cacheserver/", clientv3.WithPrefix())
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return master, rev
+	}
+	rev = response.Header.Revision
+
+	for _, v := range response.Kvs {
+		cacheServer := base.CacheServer{}
+		if err := json.Unmarshal(v.Value, &cacheServer); err != nil {
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+		if cacheServer.IP == cache.Addr {
+			return cacheServer.Master, rev
+		}
+	}
+	return master, rev
+}
+
+//setMaster 设置主节点,为空时成为主节点.
+//成为从节点之后不再接受客户端的写入,只同步主节点的数据.
+func (cache *Cache) setMaster(master string) error {
+	if master == cache.Addr {
+		return errors.New("主节点不能是当前节点")
+	}
+
+	repl := cache.Repl
+	repl.lock.Lock()
+	defer repl.lock.Unlock()
+
+	if master == repl.master {
+		return nil
+	}
+
+	if err := cache.Storage.SetReadOnly(len(master) > 0); err != nil {
+		return err
+	}
+	if repl.stop != nil {
+		close(repl.stop)
+		repl.stop = nil
+	}
+
+	repl.master = master
+	repl.linked = false
+	repl.masterSeq = 0
+	if len(master) < 1 {
+		log.Println("成为主节点")
+		return nil
+	}
+
+	log.Println("成为从节点, 主节点:", master)
+	repl.stop = make(chan struct{})
+	go cache.follow(master, repl.stop)
+	return nil
+}
+
+//follow 同步主节点的数据,连接断开之后重新连接,直到stop关闭.
+func (cache *Cache) follow(master string, stop chan struct{}) {
+	for {
+		err := cache.syncMaster(master, stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Println("同步主节点失败:", master, err)
+		cache.Repl.touch(0, false)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(REPL_RETRY_INTERVAL):
+		}
+	}
+}
+
+//syncMaster 连接主节点并写入主节点推送的数据.
+//写入之后缓冲区中没有待处理的数据时,向主节点确认已经写入的序号.
+func (cache *Cache) syncMaster(master string, stop chan struct{}) error {
+	conn, err := net.DialTimeout("tcp4", master, time.Second*5)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	//停止同步时关闭连接.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetDeadline(time.Now().Add(REPL_TIMEOUT))
+	h, err := packet.Auth(conn, cache.Secret, packet.CAP_CHECKSUM)
+	if err != nil {
+		return err
+	}
+
+	id, seq := cache.Storage.ReplState()
+	body := packet.EncodeArgs(cache.Addr, id, strconv.FormatUint(seq, 10))
+	if _, err := conn.Write(h.NewRequest(0, body, packet.REPL_SYNC)); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := h.ParseResponse(reader)
+	if err != nil {
+		return err
+	}
+	if resp.Err != errcode.NO_ERROR {
+		return errors.New(resp.Msg)
+	}
+	args, err := packet.DecodeArgs([]byte(resp.Msg))
+	if err != nil || len(args) < 2 {
+		return packet.ErrMalformed
+	}
+
+	//全量同步完成之前数据不完整,不确认序号.
+	full := args[0] == REPL_MODE_FULL
+	if full {
+		log.Println("开始全量同步, 主节点:", master)
+		cache.Repl.unsync()
+		if err := cache.Storage.Reset(); err != nil {
+			return err
+		}
+	} else {
+		if err := cache.Storage.SetReplID(args[1]); err != nil {
+			return err
+		}
+		log.Println("开始增量同步, 主节点:", master, "序号:", seq)
+	}
+
+	var masterSeq uint64
+	for {
+		conn.SetReadDeadline(time.Now().Add(REPL_TIMEOUT))
+		pkt, err := h.ParseRequest(reader)
+		if err != nil {
+			return err
+		}
+
+		switch pkt.Protocol {
+		case packet.REPL_SNAPSHOT:
+			chunk, err := packet.DecodeArgs(pkt.Body)
+			if err != nil {
+				return err
+			}
+			if err := cache.Storage.Load(chunk); err != nil {
+				return err
+			}
+		case packet.REPL_SNAPSHOT_END:
+			args, err := packet.DecodeArgs(pkt.Body)
+			if err != nil || len(args) < 2 {
+				return packet.ErrMalformed
+			}
+			seq, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return packet.ErrMalformed
+			}
+			if err := cache.Storage.SetReplState(args[0], seq); err != nil {
+				return err
+			}
+			full = false
+			masterSeq = seq
+			log.Println("全量同步完成, 主节点:", master, "序号:", seq)
+		case packet.REPL_ENTRY:
+			if len(pkt.Body) < 8 {
+				return packet.ErrMalformed
+			}
+			masterSeq = binary.BigEndian.Uint64(pkt.Body)
+			if err := cache.Storage.Apply(masterSeq, pkt.Body[8:]); err != nil {
+				return err
+			}
+		case packet.REPL_PING:
+			args, err := packet.DecodeArgs(pkt.Body)
+			if err != nil || len(args) < 1 {
+				return packet.ErrMalformed
+			}
+			if masterSeq, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+				return packet.ErrMalformed
+			}
+		default:
+			return errors.New("不支持的协议")
+		}
+		cache.Repl.touch(masterSeq, !full)
+
+		if full || reader.Buffered() > 0 {
+			continue
+		}
+		_, seq := cache.Storage.ReplState()
+		conn.SetWriteDeadline(time.Now().Add(REPL_TIMEOUT))
+		ack := packet.EncodeArgs(strconv.FormatUint(seq, 10))
+		if _, err := conn.Write(h.NewRequest(0, ack, packet.REPL_ACK)); err != nil {
+			return err
+		}
+	}
+}
+
+//replicate 处理从节点的同步请求,连接之后只用于推送复制日志.
+//请求参数为从节点ip、从节点的复制ID和序号.
+//可以增量同步时从复制日志中继续推送,否则先推送数据快照.
+func (cache *Cache) replicate(pkt packet.Request, reader *bufio.Reader, cli *Client) {
+	content, ok := cache.args(pkt.Body, 3, cli)
+	if !ok {
+		return
+	}
+	addr := content[0]
+	seq, err := strconv.ParseUint(content[2], 10, 64)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	w := bufio.NewWriter(cli.Conn)
+	send := func(num packet.BigcacheProtocol, body []byte) error {
+		cli.Conn.SetWriteDeadline(time.Now().Add(REPL_TIMEOUT))
+		_, err := w.Write(cli.Handshake.NewRequest(0, body, num))
+		return err
+	}
+
+	id, _ := cache.Storage.ReplState()
+	if cache.Storage.CanContinue(content[1], seq) {
+		log.Println("从节点增量同步:", addr, "序号:", seq)
+		cli.Write(string(packet.EncodeArgs(REPL_MODE_PARTIAL, id)), errcode.NO_ERROR)
+	} else {
+		log.Println("从节点全量同步:", addr)
+		cli.Write(string(packet.EncodeArgs(REPL_MODE_FULL, id)), errcode.NO_ERROR)
+		id, seq, err = cache.Storage.Snapshot(func(chunk []string) error {
+			return send(packet.REPL_SNAPSHOT, packet.EncodeArgs(chunk...))
+		})
+		if err == nil {
+			err = send(packet.REPL_SNAPSHOT_END, packet.EncodeArgs(id, strconv.FormatUint(seq, 10)))
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Println("从节点全量同步失败:", addr, err)
+			return
+		}
+	}
+
+	replLog := cache.Storage.ReplLog()
+	replLog.AddReplica(addr, seq)
+	defer replLog.RemoveReplica(addr)
+
+	//读取从节点确认的序号,连接断开时关闭连接,结束推送.
+	go func() {
+		for {
+			cli.Conn.SetReadDeadline(time.Now().Add(REPL_TIMEOUT))
+			pkt, err := cli.Handshake.ParseRequest(reader)
+			if err != nil {
+				cli.Conn.Close()
+				return
+			}
+
+			args, err := packet.DecodeArgs(pkt.Body)
+			if pkt.Protocol != packet.REPL_ACK || err != nil || len(args) < 1 {
+				continue
+			}
+			if n, err := strconv.ParseUint(args[0], 10, 64); err == nil {
+				replLog.Ack(addr, n)
+			}
+		}
+	}()
+
+	for {
+		entries, err := replLog.Read(seq, REPL_PING_INTERVAL)
+		if err != nil {
+			log.Println("从节点同步失败:", addr, err)
+			return
+		}
+
+		if len(entries) == 0 {
+			err = send(packet.REPL_PING, packet.EncodeArgs(strconv.FormatUint(replLog.Last(), 10)))
+		}
+		for _, entry := range entries {
+			body := make([]byte, 8+len(entry.Data))
+			binary.BigEndian.PutUint64(body, entry.Seq)
+			copy(body[8:], entry.Data)
+			if err = send(packet.REPL_ENTRY, body); err != nil {
+				break
+			}
+			seq = entry.Seq
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Println("从节点断开连接:", addr, err)
+			return
+		}
+	}
+}
+
+//ReplicaOf 设置主节点,请求参数为主节点ip,为空时成为主节点.
+func (cache *Cache) ReplicaOf(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	if err := cache.setMaster(content[0]); err != nil {
+		log.Printf("err:%+v\n", err)
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write("OK", errcode.NO_ERROR)
+}
+
+//ReplInfo 获取复制状态,依次为名称和值.
+//从节点返回主节点的序号及复制延迟,主节点返回每个从节点确认的序号,名称为 replica:从节点ip.
+func (cache *Cache) ReplInfo(body []byte, cli *Client) {
+	id, seq := cache.Storage.ReplState()
+
+	repl := cache.Repl
+	repl.lock.Lock()
+	master, linked, synced, masterSeq, contact := repl.master, repl.linked, repl.synced, repl.masterSeq, repl.contact
+	repl.lock.Unlock()
+
+	info := []string{
+		"replid", id,
+		"seq", strconv.FormatUint(seq, 10),
+	}
+	if len(master) > 0 {
+		lag := uint64(0)
+		if masterSeq > seq {
+			lag = masterSeq - seq
+		}
+		info = append(info,
+			"role", "replica",
+			"master", master,
+			"linked", strconv.FormatBool(linked),
+			"synced", strconv.FormatBool(synced),
+			"master_seq", strconv.FormatUint(masterSeq, 10),
+			"lag", strconv.FormatUint(lag, 10),
+		)
+		if !contact.IsZero() {
+			info = append(info, "last_contact", strconv.FormatInt(int64(time.Since(contact)/time.Millisecond), 10))
+		}
+	} else {
+		info = append(info, "role", "master")
+		for _, replica := range cache.Storage.ReplLog().Replicas() {
+			info = append(info, "replica:"+replica.Addr, strconv.FormatUint(replica.Seq, 10))
+		}
+	}
+
+	cli.WriteList(info)
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//SAdd 添加set成员,请求参数为key、member...,返回新增的成员个数.
+func (cache *Cache) SAdd(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.SAdd(key, content[1:])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//SRem 删除set成员,请求参数为key、member...,返回删除的成员个数.
+func (cache *Cache) SRem(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.SRem(key, content[1:])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//SMembers 获取set的所有成员,返回列表.
+func (cache *Cache) SMembers(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	list, err := cache.Storage.SMembers(content[0])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(list)
+}
+
+//SIsMember 判断是否为set成员,请求参数为key、member,返回1或0.
+func (cache *Cache) SIsMember(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	ok, err := cache.Storage.SIsMember(content[0], content[1])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	if !ok {
+		cli.Write("0", errcode.NO_ERROR)
+		return
+	}
+	cli.Write("1", errcode.NO_ERROR)
+}
+
+//SCard 获取set的成员个数.
+func (cache *Cache) SCard(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	n, err := cache.Storage.SCard(content[0])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//SInter 获取多个set的交集,请求参数为key...,返回列表.
+//所有key需要在同一个插槽中,由proxy保证.
+func (cache *Cache) SInter(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, key := range content {
+		list, err := cache.Storage.SMembers(key)
+		if err != nil {
+			cli.WriteError(err)
+			return
+		}
+		for _, member := range list {
+			counts[member]++
+		}
+	}
+
+	list := []string{}
+	for member, n := range counts {
+		if n == len(content) {
+			list = append(list, member)
+		}
+	}
+	sort.Strings(list)
+
+	cli.WriteList(list)
+}
+
+//SUnion 获取多个set的并集,请求参数为key...,返回列表.
+//所有key需要在同一个插槽中,由proxy保证.
+func (cache *Cache) SUnion(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	members := map[string]bool{}
+	for _, key := range content {
+		list, err := cache.Storage.SMembers(key)
+		if err != nil {
+			cli.WriteError(err)
+			return
+		}
+		for _, member := range list {
+			members[member] = true
+		}
+	}
+
+	list := make([]string, 0, len(members))
+	for member := range members {
+		list = append(list, member)
+	}
+	sort.Strings(list)
+
+	cli.WriteList(list)
+}
@@ -1,30 +1,137 @@
 package handler
 
 import (
+	"encoding/binary"
+	"errors"
 	"log"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//数据文件中key的前缀.
+const (
+	KEY_PREFIX_DATA   = 'd' //数据,格式: d + 插槽号 + key.
+	KEY_PREFIX_SUB    = 'f' //复合类型的元素,格式: f + 插槽号 + key长度 + key + 元素.
+	KEY_PREFIX_EXPIRE = 'e' //过期时间索引,格式: e + 过期时间 + key.
+	KEY_PREFIX_REPL   = 'r' //复制状态,不参与同步.
+	KEY_PREFIX_LAYOUT = 'v' //数据文件的格式版本及插槽数量,不参与同步.
+)
+
+//SLOT_PREFIX_LEN 数据key的前缀长度.
+const SLOT_PREFIX_LEN = 5
+
+//VALUE_HEADER_LEN 数据value的头部长度,存放过期时间和数据类型.
+const VALUE_HEADER_LEN = 9
+
+//DataType 数据类型.
+type DataType byte
+
+const (
+	TYPE_STRING DataType = 1 //字符串.
+	TYPE_HASH   DataType = 2 //哈希.
+	TYPE_LIST   DataType = 3 //列表.
+	TYPE_SET    DataType = 4 //集合.
+	TYPE_ZSET   DataType = 5 //有序集合.
 )
 
+//ErrWrongType 对key执行了与其数据类型不符的操作.
+var ErrWrongType = errors.New(errcode.MSG_WRONG_TYPE)
+
 type Storage struct {
-	path string
-	db   *leveldb.DB
+	path     string
+	db       *leveldb.DB
+	lock     *sync.Mutex //写入数据和追加复制日志时加锁,保证日志顺序与写入顺序一致.
+	replID   string      //复制ID,从节点与主节点一致时才可以增量同步.
+	prevID   string      //成为主节点之前的复制ID.
+	prevSeq  uint64      //成为主节点时的序号.
+	seq      uint64      //最新写入的复制日志序号.
+	readonly bool        //从节点只读.
+	log      *ReplLog
 }
 type StorageEngine interface {
-	Write(key, val string) error
+	Write(key, val string, expireAt int64) error
 	Read(key string) (string, error)
 	Delete(key string) error
+	ExpireAt(key string) (int64, error)
+	SetExpireAt(key string, expireAt int64) error
+	ExpiredKeys(now int64, limit int) ([]ExpireIndex, error)
+	DeleteExpired(index ExpireIndex) error
+	Dump(key string) (string, error)
+	Restore(key, data string) error
+	SlotRange(slot uint32, start string, fn func(key string) bool) error
+	SlotCount(slot uint32) (int, error)
+
+	Apply(seq uint64, data []byte) error
+	ReplState() (id string, seq uint64)
+	ReplLog() *ReplLog
+	CanContinue(id string, seq uint64) bool
+	SetReadOnly(readonly bool) error
+	SetReplID(id string) error
+	Snapshot(fn func(chunk []string) error) (id string, seq uint64, err error)
+	Reset() error
+	Load(chunk []string) error
+	SetReplState(id string, seq uint64) error
+
+	HSet(key string, fields, values []string) (int, error)
+	HGet(key, field string) (string, error)
+	HMGet(key string, fields []string) ([]*string, error)
+	HGetAll(key string) ([]string, error)
+	HDel(key string, fields []string) (int, error)
+	HLen(key string) (int, error)
+
+	LPush(key string, values []string, left bool) (int, error)
+	LPop(key string, count int, left bool) ([]string, error)
+	LRange(key string, start, stop int) ([]string, error)
+	LLen(key string) (int, error)
+	LTrim(key string, start, stop int) error
+
+	SAdd(key string, members []string) (int, error)
+	SRem(key string, members []string) (int, error)
+	SMembers(key string) ([]string, error)
+	SIsMember(key, member string) (bool, error)
+	SCard(key string) (int, error)
+
+	ZAdd(key string, members []ZMember) (int, error)
+	ZScore(key, member string) (float64, error)
+	ZRem(key string, members []string) (int, error)
+	ZCard(key string) (int, error)
+	ZRange(key string, start, stop int) ([]ZMember, error)
+	ZRangeByScore(key string, min, max float64, minEx, maxEx bool, offset, count int) ([]ZMember, error)
 }
 
-func NewStorage(path string) StorageEngine {
+//ExpireIndex 过期时间索引.
+type ExpireIndex struct {
+	Key      string
+	ExpireAt int64
+}
+
+//meta 数据的元信息,字符串类型的Value为值,复合类型的Value为元素个数等信息.
+type meta struct {
+	Type     DataType
+	ExpireAt int64
+	Value    []byte
+}
+
+func NewStorage(path string, log *ReplLog) StorageEngine {
 	var storageEngine StorageEngine
 
 	s := &Storage{
 		path: path,
+		lock: &sync.Mutex{},
+		log:  log,
 	}
 
 	//初始化存储引擎
 	s.initdb()
+	s.loadRepl()
 	storageEngine = s
 
 	return storageEngine
@@ -37,22 +144,178 @@ func (s *Storage) initdb() {
 		panic(err)
 	}
 	s.db = db
+
+	//格式或插槽数量不一致时已有的数据无法读取,不能启动.
+	if err := s.checkLayout(); err != nil {
+		log.Printf("err:%+v\n", err)
+		os.Exit(1)
+	}
+}
+
+//Now 当前时间,单位毫秒.
+func Now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+//slotPrefix 插槽在数据文件中的前缀.
+func slotPrefix(slot uint32) []byte {
+	prefix := make([]byte, SLOT_PREFIX_LEN)
+	prefix[0] = KEY_PREFIX_DATA
+	binary.BigEndian.PutUint32(prefix[1:], slot)
+	return prefix
+}
+
+//encodeKey 数据文件中的key,以插槽号作为前缀,同一个插槽的数据连续存放.
+func encodeKey(key string) []byte {
+	return append(slotPrefix(utils.Slot(key)), key...)
+}
+
+//subPrefix 复合类型元素的key前缀.
+func subPrefix(key string) []byte {
+	buf := make([]byte, 9, 9+len(key))
+	buf[0] = KEY_PREFIX_SUB
+	binary.BigEndian.PutUint32(buf[1:5], utils.Slot(key))
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(key)))
+	return append(buf, key...)
+}
+
+//subKey 复合类型元素的key.
+func subKey(key string, sub []byte) []byte {
+	return append(subPrefix(key), sub...)
+}
+
+//expireKey 过期时间索引的key,按过期时间排序.
+func expireKey(key string, expireAt int64) []byte {
+	buf := make([]byte, 9, 9+len(key))
+	buf[0] = KEY_PREFIX_EXPIRE
+	binary.BigEndian.PutUint64(buf[1:], uint64(expireAt))
+	return append(buf, key...)
+}
+
+//encodeMeta 数据value,前8个字节为过期时间(毫秒,0表示永不过期),第9个字节为数据类型.
+func encodeMeta(m *meta) []byte {
+	buf := make([]byte, VALUE_HEADER_LEN, VALUE_HEADER_LEN+len(m.Value))
+	binary.BigEndian.PutUint64(buf, uint64(m.ExpireAt))
+	buf[8] = byte(m.Type)
+	return append(buf, m.Value...)
+}
+
+//decodeMeta 解析数据value.
+func decodeMeta(buf []byte) *meta {
+	m := &meta{Type: TYPE_STRING}
+	if len(buf) < VALUE_HEADER_LEN {
+		m.Value = append([]byte{}, buf...)
+		return m
+	}
+	m.ExpireAt = int64(binary.BigEndian.Uint64(buf))
+	m.Type = DataType(buf[8])
+	m.Value = append([]byte{}, buf[VALUE_HEADER_LEN:]...)
+	return m
+}
+
+//expired 判断是否已经过期.
+func expired(expireAt int64) bool {
+	return expireAt > 0 && expireAt <= Now()
+}
+
+//getRawMeta 读取元信息,包括已经过期但还未被清理的数据.
+//修改数据类型之前需要根据原来的类型清除元素,不能忽略过期的数据.
+func (s *Storage) getRawMeta(key string) (*meta, error) {
+	buf, err := s.db.Get(encodeKey(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMeta(buf), nil
+}
+
+//getMeta 读取元信息,已经过期的数据视为不存在,由过期清理删除.
+func (s *Storage) getMeta(key string) (*meta, error) {
+	m, err := s.getRawMeta(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired(m.ExpireAt) {
+		return nil, leveldb.ErrNotFound
+	}
+
+	return m, nil
+}
+
+//getTypedMeta 读取指定类型的元信息,key不存在时返回nil.
+func (s *Storage) getTypedMeta(key string, types DataType) (*meta, error) {
+	m, err := s.getMeta(key)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Type != types {
+		return nil, ErrWrongType
+	}
+	return m, nil
+}
+
+//putMeta 写入元信息,过期时间索引中旧的记录由过期清理时删除.
+func (s *Storage) putMeta(batch *leveldb.Batch, key string, m *meta) {
+	batch.Put(encodeKey(key), encodeMeta(m))
+	if m.ExpireAt > 0 {
+		batch.Put(expireKey(key, m.ExpireAt), nil)
+	}
+}
+
+//purge 删除key的元信息及所有元素.
+func (s *Storage) purge(batch *leveldb.Batch, key string) error {
+	batch.Delete(encodeKey(key))
+
+	iter := s.db.NewIterator(util.BytesPrefix(subPrefix(key)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+
+	return iter.Error()
+}
+
+//create 新建复合类型的key,清除已经过期但还未被清理的旧数据.
+func (s *Storage) create(batch *leveldb.Batch, key string, types DataType) (*meta, error) {
+	if err := s.purge(batch, key); err != nil {
+		return nil, err
+	}
+	return &meta{Type: types}, nil
 }
 
 //Read 读取操作.
 func (s *Storage) Read(key string) (str string, err error) {
-	val, err := s.db.Get([]byte(key), nil)
+	m, err := s.getMeta(key)
 	if err != nil {
-		log.Printf("err:%+v\n", err)
+		if err != leveldb.ErrNotFound {
+			log.Printf("err:%+v\n", err)
+		}
 		return str, err
 	}
 
-	return string(val), nil
+	if m.Type != TYPE_STRING {
+		return str, ErrWrongType
+	}
+
+	return string(m.Value), nil
 }
 
-//Write 写操作.
-func (s *Storage) Write(key, value string) error {
-	err := s.db.Put([]byte(key), []byte(value), nil)
+//Write 写操作,expireAt为过期时间(毫秒),0表示永不过期.
+//key为其他类型时直接覆盖,包括已经过期但还未被清理的复合类型,其元素一起删除.
+func (s *Storage) Write(key, value string, expireAt int64) error {
+	batch := new(leveldb.Batch)
+	if m, err := s.getRawMeta(key); err == nil && m.Type != TYPE_STRING {
+		if err := s.purge(batch, key); err != nil {
+			return err
+		}
+	}
+
+	s.putMeta(batch, key, &meta{Type: TYPE_STRING, ExpireAt: expireAt, Value: []byte(value)})
+	err := s.commit(batch)
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		return err
@@ -63,7 +326,13 @@ func (s *Storage) Write(key, value string) error {
 
 //Delete 删除.
 func (s *Storage) Delete(key string) error {
-	err := s.db.Delete([]byte(key), nil)
+	batch := new(leveldb.Batch)
+	if err := s.purge(batch, key); err != nil {
+		log.Printf("err:%+v\n", err)
+		return err
+	}
+
+	err := s.commit(batch)
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		return err
@@ -71,3 +340,173 @@ func (s *Storage) Delete(key string) error {
 
 	return nil
 }
+
+//ExpireAt 获取过期时间,0表示永不过期.
+func (s *Storage) ExpireAt(key string) (int64, error) {
+	m, err := s.getMeta(key)
+	if err != nil {
+		return 0, err
+	}
+	return m.ExpireAt, nil
+}
+
+//SetExpireAt 设置过期时间,0表示永不过期.
+func (s *Storage) SetExpireAt(key string, expireAt int64) error {
+	m, err := s.getMeta(key)
+	if err != nil {
+		return err
+	}
+
+	m.ExpireAt = expireAt
+	batch := new(leveldb.Batch)
+	s.putMeta(batch, key, m)
+	return s.commit(batch)
+}
+
+//ExpiredKeys 获取过期时间早于now的索引,最多返回limit条.
+func (s *Storage) ExpiredKeys(now int64, limit int) (list []ExpireIndex, err error) {
+	rng := &util.Range{
+		Start: expireKey("", 0),
+		Limit: expireKey("", now+1),
+	}
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	for len(list) < limit && iter.Next() {
+		index := iter.Key()
+		list = append(list, ExpireIndex{
+			Key:      string(index[9:]),
+			ExpireAt: int64(binary.BigEndian.Uint64(index[1:9])),
+		})
+	}
+
+	if err := iter.Error(); err != nil {
+		log.Printf("err:%+v\n", err)
+		return list, err
+	}
+
+	return list, nil
+}
+
+//DeleteExpired 删除过期的数据及其索引.
+//数据的过期时间被修改过时,只删除旧的索引.
+func (s *Storage) DeleteExpired(index ExpireIndex) error {
+	batch := new(leveldb.Batch)
+	buf, err := s.db.Get(encodeKey(index.Key), nil)
+	if err == nil && decodeMeta(buf).ExpireAt == index.ExpireAt {
+		if err := s.purge(batch, index.Key); err != nil {
+			return err
+		}
+	}
+	batch.Delete(expireKey(index.Key, index.ExpireAt))
+
+	return s.commit(batch)
+}
+
+//Dump 序列化key的数据及所有元素,用于迁移.
+//使用与请求参数相同的编码,第一个元素为数据value,之后为复合类型元素的key和value.
+func (s *Storage) Dump(key string) (string, error) {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return "", err
+	}
+	defer snap.Release()
+
+	buf, err := snap.Get(encodeKey(key), nil)
+	if err != nil {
+		return "", err
+	}
+	if expired(decodeMeta(buf).ExpireAt) {
+		return "", leveldb.ErrNotFound
+	}
+
+	items := []string{string(buf)}
+	prefix := subPrefix(key)
+	iter := snap.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		items = append(items, string(iter.Key()[len(prefix):]), string(iter.Value()))
+	}
+	if err := iter.Error(); err != nil {
+		return "", err
+	}
+
+	return string(packet.EncodeArgs(items...)), nil
+}
+
+//Restore 写入Dump序列化的数据,已经过期的数据直接丢弃.
+func (s *Storage) Restore(key, data string) error {
+	items, err := packet.DecodeArgs([]byte(data))
+	if err != nil {
+		return err
+	}
+	if len(items)%2 != 1 {
+		return packet.ErrMalformed
+	}
+
+	m := decodeMeta([]byte(items[0]))
+	if expired(m.ExpireAt) {
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	if err := s.purge(batch, key); err != nil {
+		return err
+	}
+
+	s.putMeta(batch, key, m)
+	for i := 1; i < len(items); i += 2 {
+		batch.Put(subKey(key, []byte(items[i])), []byte(items[i+1]))
+	}
+	return s.commit(batch)
+}
+
+//SlotRange 按key的顺序遍历插槽中大于等于start的key,fn返回false时停止遍历.
+//已经过期的key不会被遍历.
+func (s *Storage) SlotRange(slot uint32, start string, fn func(key string) bool) error {
+	prefix := slotPrefix(slot)
+	rng := util.BytesPrefix(prefix)
+	rng.Start = append(prefix, start...)
+
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if expired(decodeMeta(iter.Value()).ExpireAt) {
+			continue
+		}
+
+		key := string(iter.Key()[SLOT_PREFIX_LEN:])
+		if !fn(key) {
+			break
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		log.Printf("err:%+v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+//SlotCount 统计插槽中的数据条数.
+func (s *Storage) SlotCount(slot uint32) (int, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(slotPrefix(slot)), nil)
+	defer iter.Release()
+
+	total := 0
+	for iter.Next() {
+		if expired(decodeMeta(iter.Value()).ExpireAt) {
+			continue
+		}
+		total++
+	}
+
+	if err := iter.Error(); err != nil {
+		log.Printf("err:%+v\n", err)
+		return total, err
+	}
+
+	return total, nil
+}
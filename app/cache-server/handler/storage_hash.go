@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//hash的元信息为8个字节的field个数,每个field单独存放: f + 插槽号 + key长度 + key + field.
+
+//metaCount 复合类型的元素个数.
+func metaCount(m *meta) int {
+	if len(m.Value) < 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(m.Value))
+}
+
+//setMetaCount 设置复合类型的元素个数.
+func setMetaCount(m *meta, n int) {
+	if len(m.Value) < 8 {
+		m.Value = make([]byte, 8)
+	}
+	binary.BigEndian.PutUint64(m.Value, uint64(n))
+}
+
+//HSet 写入field,返回新增的field个数.
+func (s *Storage) HSet(key string, fields, values []string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_HASH)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	fresh := m == nil
+	if fresh {
+		if m, err = s.create(batch, key, TYPE_HASH); err != nil {
+			return 0, err
+		}
+	}
+
+	count := metaCount(m)
+	added := map[string]bool{}
+	for i, field := range fields {
+		sub := subKey(key, []byte(field))
+		batch.Put(sub, []byte(values[i]))
+		if added[field] {
+			continue
+		}
+
+		if !fresh {
+			_, err := s.db.Get(sub, nil)
+			if err == nil {
+				continue
+			}
+			if err != leveldb.ErrNotFound {
+				return 0, err
+			}
+		}
+		added[field] = true
+	}
+
+	setMetaCount(m, count+len(added))
+	s.putMeta(batch, key, m)
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+
+	return len(added), nil
+}
+
+//HGet 读取field.
+func (s *Storage) HGet(key, field string) (string, error) {
+	m, err := s.getTypedMeta(key, TYPE_HASH)
+	if err != nil {
+		return "", err
+	}
+	if m == nil {
+		return "", leveldb.ErrNotFound
+	}
+
+	val, err := s.db.Get(subKey(key, []byte(field)), nil)
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+//HMGet 读取多个field,不存在的field返回nil.
+func (s *Storage) HMGet(key string, fields []string) ([]*string, error) {
+	list := make([]*string, len(fields))
+	m, err := s.getTypedMeta(key, TYPE_HASH)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	for i, field := range fields {
+		val, err := s.db.Get(subKey(key, []byte(field)), nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return list, err
+		}
+		str := string(val)
+		list[i] = &str
+	}
+
+	return list, nil
+}
+
+//HGetAll 读取所有field,返回field、value交替排列的列表.
+func (s *Storage) HGetAll(key string) ([]string, error) {
+	list := []string{}
+	m, err := s.getTypedMeta(key, TYPE_HASH)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	prefix := subPrefix(key)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		list = append(list, string(iter.Key()[len(prefix):]), string(iter.Value()))
+	}
+
+	return list, iter.Error()
+}
+
+//HDel 删除field,返回删除的field个数,所有field都被删除时同时删除key.
+func (s *Storage) HDel(key string, fields []string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_HASH)
+	if err != nil || m == nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	deleted := map[string]bool{}
+	for _, field := range fields {
+		if deleted[field] {
+			continue
+		}
+
+		sub := subKey(key, []byte(field))
+		_, err := s.db.Get(sub, nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		deleted[field] = true
+		batch.Delete(sub)
+	}
+
+	count := metaCount(m) - len(deleted)
+	if count > 0 {
+		setMetaCount(m, count)
+		s.putMeta(batch, key, m)
+	} else {
+		batch.Delete(encodeKey(key))
+	}
+
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+	return len(deleted), nil
+}
+
+//HLen 获取field个数.
+func (s *Storage) HLen(key string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_HASH)
+	if err != nil || m == nil {
+		return 0, err
+	}
+	return metaCount(m), nil
+}
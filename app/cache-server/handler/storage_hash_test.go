@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//TestHash 新增和删除的field个数,删除最后一个field时删除key,类型不一致时返回WRONGTYPE.
+func TestHash(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+	cache := &Cache{Storage: s, Lock: NewKeyLock(), MaxInflight: 1}
+
+	if n, err := s.HSet("h", []string{"a", "b"}, []string{"1", "2"}); err != nil || n != 2 {
+		t.Fatalf("HSET: %d %v", n, err)
+	}
+	if n, err := s.HSet("h", []string{"a", "c"}, []string{"3", "4"}); err != nil || n != 1 {
+		t.Fatalf("HSET已存在的field: %d %v", n, err)
+	}
+	list, err := s.HMGet("h", []string{"a", "x"})
+	if err != nil || len(list) != 2 || *list[0] != "3" || list[1] != nil {
+		t.Fatalf("HMGET: %v %v", list, err)
+	}
+	if pkt := call(t, cache, cache.HIncrBy, "h", "b", "10"); pkt.Msg != "12" {
+		t.Fatalf("HINCRBY: %+v", pkt)
+	}
+
+	if n, err := s.HDel("h", []string{"a", "b", "c", "x"}); err != nil || n != 3 {
+		t.Fatalf("HDEL: %d %v", n, err)
+	}
+	if _, err := s.db.Get(encodeKey("h"), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("删除所有field之后key仍然存在: %v", err)
+	}
+
+	if err := s.Write("str", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.HGet("str", "a"); err != ErrWrongType {
+		t.Fatalf("HGET字符串: %v", err)
+	}
+}
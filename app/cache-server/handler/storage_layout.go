@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//STORAGE_LAYOUT 数据文件的格式版本,key的编码方式改变时递增.
+const STORAGE_LAYOUT = 1
+
+//UPGRADE_BATCH 转换数据文件格式时每批写入的条数.
+const UPGRADE_BATCH = 1000
+
+//layoutKey 数据文件的格式版本及插槽数量,不参与同步,全量同步时保留.
+var layoutKey = []byte{KEY_PREFIX_LAYOUT}
+
+//ErrLayout 数据文件的格式版本或插槽数量与当前的不一致.
+var ErrLayout = errors.New("数据文件的格式版本或插槽数量与当前的不一致")
+
+//encodeLayout 格式: 4字节格式版本 + 4字节插槽数量.
+func encodeLayout(version, slotCount uint32) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf, version)
+	binary.BigEndian.PutUint32(buf[4:], slotCount)
+	return buf
+}
+
+//checkLayout 检查数据文件的格式版本及插槽数量.
+//key所在的插槽由插槽数量决定,插槽数量改变之后已有的数据无法读取,因此不一致时返回错误,不能启动.
+//没有格式标记的空数据文件写入当前的格式;有数据时为key直接保存的旧版本数据文件,转换为当前的格式.
+func (s *Storage) checkLayout() error {
+	buf, err := s.db.Get(layoutKey, nil)
+	if err == nil {
+		if len(buf) != 8 {
+			return ErrLayout
+		}
+		version, count := binary.BigEndian.Uint32(buf), binary.BigEndian.Uint32(buf[4:])
+		if version != STORAGE_LAYOUT || count != utils.SlotCount() {
+			return fmt.Errorf("%w: 数据文件 版本%d 插槽数量%d, 当前 版本%d 插槽数量%d", ErrLayout, version, count, STORAGE_LAYOUT, utils.SlotCount())
+		}
+		return nil
+	}
+	if err != leveldb.ErrNotFound {
+		return err
+	}
+
+	iter := s.db.NewIterator(nil, nil)
+	empty := !iter.Next()
+	iter.Release()
+	if empty {
+		return s.db.Put(layoutKey, encodeLayout(STORAGE_LAYOUT, utils.SlotCount()), nil)
+	}
+	return s.upgradeLayout()
+}
+
+//upgradeLayout 将key直接保存的旧版本数据文件转换为当前的格式,旧版本的数据都是字符串.
+//转换后的数据写入新的数据文件,完成之后替换,原数据文件保留为.v0目录,转换中断时重新转换.
+func (s *Storage) upgradeLayout() error {
+	path := filepath.Clean(s.path)
+	tmp, backup := path+".upgrade", path+".v0"
+	log.Println("数据文件为旧版本格式, 开始转换:", path)
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	db, err := leveldb.OpenFile(tmp, nil)
+	if err != nil {
+		return err
+	}
+
+	total, err := copyLegacy(s.db, db)
+	if err == nil {
+		err = db.Put(layoutKey, encodeLayout(STORAGE_LAYOUT, utils.SlotCount()), nil)
+	}
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if s.db, err = leveldb.OpenFile(path, nil); err != nil {
+		return err
+	}
+
+	log.Println("数据文件转换完成, 共", total, "条数据, 原数据文件:", backup)
+	return nil
+}
+
+//copyLegacy 将旧版本数据文件中的key和字符串写入新的数据文件,返回写入的条数.
+func copyLegacy(src, dst *leveldb.DB) (total int, err error) {
+	iter := src.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		m := &meta{Type: TYPE_STRING, Value: iter.Value()}
+		batch.Put(encodeKey(string(iter.Key())), encodeMeta(m))
+		total++
+		if batch.Len() >= UPGRADE_BATCH {
+			if err := dst.Write(batch, nil); err != nil {
+				return total, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return total, err
+	}
+	return total, dst.Write(batch, nil)
+}
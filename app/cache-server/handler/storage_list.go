@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//list的元信息为8个字节的head和8个字节的tail,元素的序号范围为[head, tail).
+//每个元素单独存放: f + 插槽号 + key长度 + key + 序号.
+
+//LIST_INIT_SEQ 新建list的初始序号,从中间开始以便向两端插入.
+const LIST_INIT_SEQ uint64 = 1 << 63
+
+//listRange 获取list的head和tail.
+func listRange(m *meta) (head, tail uint64) {
+	if len(m.Value) < 16 {
+		return LIST_INIT_SEQ, LIST_INIT_SEQ
+	}
+	return binary.BigEndian.Uint64(m.Value), binary.BigEndian.Uint64(m.Value[8:])
+}
+
+//setListRange 设置list的head和tail.
+func setListRange(m *meta, head, tail uint64) {
+	m.Value = make([]byte, 16)
+	binary.BigEndian.PutUint64(m.Value, head)
+	binary.BigEndian.PutUint64(m.Value[8:], tail)
+}
+
+//listKey list元素的key.
+func listKey(key string, seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return subKey(key, buf)
+}
+
+//listIndex 将redis风格的下标(负数表示从尾部开始)转换为[start, stop]区间,区间为空时返回false.
+func listIndex(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start = n + start
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+//LPush 插入元素,left为true时从头部插入,返回插入之后的元素个数.
+func (s *Storage) LPush(key string, values []string, left bool) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_LIST)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	if m == nil {
+		if m, err = s.create(batch, key, TYPE_LIST); err != nil {
+			return 0, err
+		}
+	}
+
+	head, tail := listRange(m)
+	for _, val := range values {
+		if left {
+			head--
+			batch.Put(listKey(key, head), []byte(val))
+		} else {
+			batch.Put(listKey(key, tail), []byte(val))
+			tail++
+		}
+	}
+
+	setListRange(m, head, tail)
+	s.putMeta(batch, key, m)
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+	return int(tail - head), nil
+}
+
+//LPop 弹出最多count个元素,left为true时从头部弹出,所有元素都被弹出时同时删除key.
+func (s *Storage) LPop(key string, count int, left bool) ([]string, error) {
+	list := []string{}
+	m, err := s.getTypedMeta(key, TYPE_LIST)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	head, tail := listRange(m)
+	batch := new(leveldb.Batch)
+	for i := 0; i < count && head < tail; i++ {
+		seq := head
+		if left {
+			head++
+		} else {
+			tail--
+			seq = tail
+		}
+
+		sub := listKey(key, seq)
+		val, err := s.db.Get(sub, nil)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, string(val))
+		batch.Delete(sub)
+	}
+
+	if head < tail {
+		setListRange(m, head, tail)
+		s.putMeta(batch, key, m)
+	} else {
+		batch.Delete(encodeKey(key))
+	}
+
+	if err := s.commit(batch); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+//LRange 获取下标在[start, stop]之间的元素,负数表示从尾部开始.
+func (s *Storage) LRange(key string, start, stop int) ([]string, error) {
+	list := []string{}
+	m, err := s.getTypedMeta(key, TYPE_LIST)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	head, tail := listRange(m)
+	start, stop, ok := listIndex(start, stop, int(tail-head))
+	if !ok {
+		return list, nil
+	}
+
+	rng := &util.Range{
+		Start: listKey(key, head+uint64(start)),
+		Limit: listKey(key, head+uint64(stop)+1),
+	}
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+	for iter.Next() {
+		list = append(list, string(iter.Value()))
+	}
+
+	return list, iter.Error()
+}
+
+//LLen 获取元素个数.
+func (s *Storage) LLen(key string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_LIST)
+	if err != nil || m == nil {
+		return 0, err
+	}
+
+	head, tail := listRange(m)
+	return int(tail - head), nil
+}
+
+//LTrim 只保留下标在[start, stop]之间的元素,区间为空时删除key.
+func (s *Storage) LTrim(key string, start, stop int) error {
+	m, err := s.getTypedMeta(key, TYPE_LIST)
+	if err != nil || m == nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	head, tail := listRange(m)
+	start, stop, ok := listIndex(start, stop, int(tail-head))
+	if !ok {
+		if err := s.purge(batch, key); err != nil {
+			return err
+		}
+		return s.commit(batch)
+	}
+
+	newHead := head + uint64(start)
+	newTail := head + uint64(stop) + 1
+	for seq := head; seq < newHead; seq++ {
+		batch.Delete(listKey(key, seq))
+	}
+	for seq := newTail; seq < tail; seq++ {
+		batch.Delete(listKey(key, seq))
+	}
+
+	setListRange(m, newHead, newTail)
+	s.putMeta(batch, key, m)
+	return s.commit(batch)
+}
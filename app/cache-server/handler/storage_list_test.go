@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+//TestListOrder 两端插入、弹出及LTRIM之后元素的顺序.
+func TestListOrder(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+
+	if _, err := s.LPush("l", []string{"c", "d"}, false); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.LPush("l", []string{"b", "a"}, true)
+	if err != nil || n != 4 {
+		t.Fatalf("LPUSH: %d %v", n, err)
+	}
+
+	list, err := s.LRange("l", 0, -1)
+	if err != nil || !reflect.DeepEqual(list, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("LRANGE: %v %v", list, err)
+	}
+	if list, _ := s.LRange("l", -2, 10); !reflect.DeepEqual(list, []string{"c", "d"}) {
+		t.Fatalf("LRANGE负数下标: %v", list)
+	}
+
+	if list, err := s.LPop("l", 1, true); err != nil || !reflect.DeepEqual(list, []string{"a"}) {
+		t.Fatalf("LPOP: %v %v", list, err)
+	}
+	if list, err := s.LPop("l", 1, false); err != nil || !reflect.DeepEqual(list, []string{"d"}) {
+		t.Fatalf("RPOP: %v %v", list, err)
+	}
+
+	if err := s.LTrim("l", 1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.LRange("l", 0, -1); !reflect.DeepEqual(list, []string{"c"}) {
+		t.Fatalf("LTRIM: %v", list)
+	}
+	if n, err := s.LLen("l"); err != nil || n != 1 {
+		t.Fatalf("LLEN: %d %v", n, err)
+	}
+	if n := subCount(t, s, "l"); n != 1 {
+		t.Fatalf("残留的元素: %d", n)
+	}
+}
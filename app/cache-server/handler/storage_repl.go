@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//复制状态在数据文件中的key,随数据一起写入,从节点写入复制日志时同时更新.
+var (
+	replIDKey  = []byte{KEY_PREFIX_REPL, 'i'}
+	replSeqKey = []byte{KEY_PREFIX_REPL, 's'}
+)
+
+//SNAPSHOT_CHUNK_SIZE 全量同步时每个数据包的大小.
+const SNAPSHOT_CHUNK_SIZE = 1 << 20
+
+//RESET_BATCH 清空数据时每批删除的条数.
+const RESET_BATCH = 1000
+
+//ErrReadOnly 从节点不接受客户端写入.
+var ErrReadOnly = errors.New(errcode.MSG_READONLY)
+
+var ErrReplSeq = errors.New("复制日志序号不连续")
+
+//newReplID 生成新的复制ID,主节点的数据历史发生变化时更换.
+func newReplID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func encodeSeq(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return buf
+}
+
+//loadRepl 加载复制ID和序号,数据文件中没有时生成新的复制ID.
+func (s *Storage) loadRepl() {
+	id, err := s.db.Get(replIDKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		panic(err)
+	}
+	if len(id) == 0 {
+		id = []byte(newReplID())
+		if err := s.db.Put(replIDKey, id, nil); err != nil {
+			panic(err)
+		}
+	}
+	s.replID = string(id)
+
+	seq, err := s.db.Get(replSeqKey, nil)
+	if err == nil && len(seq) == 8 {
+		s.seq = binary.BigEndian.Uint64(seq)
+	}
+	s.log.Reset(s.seq)
+}
+
+//commit 写入数据并追加复制日志,同步复制时等待从节点确认.
+func (s *Storage) commit(batch *leveldb.Batch) error {
+	s.lock.Lock()
+	if s.readonly {
+		s.lock.Unlock()
+		return ErrReadOnly
+	}
+
+	seq := s.seq + 1
+	batch.Put(replSeqKey, encodeSeq(seq))
+	err := s.write(seq, batch)
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.log.WaitAck(seq)
+}
+
+//write 写入一条复制日志对应的数据,调用时需要持有锁.
+func (s *Storage) write(seq uint64, batch *leveldb.Batch) error {
+	if err := s.db.Write(batch, nil); err != nil {
+		log.Printf("err:%+v\n", err)
+		return err
+	}
+
+	s.seq = seq
+	s.log.Append(seq, batch.Dump())
+	return nil
+}
+
+//Apply 从节点写入主节点推送的复制日志,数据中已经包含了序号的更新.
+func (s *Storage) Apply(seq uint64, data []byte) error {
+	batch := new(leveldb.Batch)
+	if err := batch.Load(data); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if seq != s.seq+1 {
+		return ErrReplSeq
+	}
+	return s.write(seq, batch)
+}
+
+//ReplState 获取复制ID和最新的序号.
+func (s *Storage) ReplState() (id string, seq uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.replID, s.seq
+}
+
+//ReplLog 获取复制日志.
+func (s *Storage) ReplLog() *ReplLog {
+	return s.log
+}
+
+//CanContinue 从节点是否可以从seq之后增量同步.
+//从节点的复制ID与当前一致,或者是成为主节点之前的复制ID且没有超过当时的序号.
+//复制ID为空的从节点全量同步没有完成,只能全量同步.
+func (s *Storage) CanContinue(id string, seq uint64) bool {
+	if len(id) < 1 {
+		return false
+	}
+
+	s.lock.Lock()
+	match := id == s.replID || (id == s.prevID && seq <= s.prevSeq)
+	s.lock.Unlock()
+
+	return match && s.log.Has(seq)
+}
+
+//SetReadOnly 设置为只读,从节点只能通过Apply写入.
+//从节点成为主节点时更换复制ID,之前的复制ID保留用于其他从节点增量同步.
+func (s *Storage) SetReadOnly(readonly bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.readonly && !readonly {
+		id := newReplID()
+		if err := s.db.Put(replIDKey, []byte(id), nil); err != nil {
+			return err
+		}
+		s.prevID, s.prevSeq = s.replID, s.seq
+		s.replID = id
+	}
+	s.readonly = readonly
+	return nil
+}
+
+//SetReplID 增量同步时使用主节点的复制ID.
+func (s *Storage) SetReplID(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id == s.replID {
+		return nil
+	}
+	if err := s.db.Put(replIDKey, []byte(id), nil); err != nil {
+		return err
+	}
+	s.replID = id
+	return nil
+}
+
+//Snapshot 遍历当前数据的快照,返回快照对应的复制ID和序号,之后的数据通过复制日志同步.
+//fn每次处理一批数据,使用与请求参数相同的编码,依次为key和value.
+func (s *Storage) Snapshot(fn func(chunk []string) error) (id string, seq uint64, err error) {
+	s.lock.Lock()
+	snap, err := s.db.GetSnapshot()
+	id, seq = s.replID, s.seq
+	s.lock.Unlock()
+	if err != nil {
+		return id, seq, err
+	}
+	defer snap.Release()
+
+	iter := snap.NewIterator(nil, nil)
+	defer iter.Release()
+
+	var chunk []string
+	size := 0
+	for iter.Next() {
+		if prefix := iter.Key()[0]; prefix == KEY_PREFIX_REPL || prefix == KEY_PREFIX_LAYOUT {
+			continue
+		}
+
+		key, value := string(iter.Key()), string(iter.Value())
+		chunk = append(chunk, key, value)
+		size += len(key) + len(value)
+		if size >= SNAPSHOT_CHUNK_SIZE {
+			if err := fn(chunk); err != nil {
+				return id, seq, err
+			}
+			chunk, size = nil, 0
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return id, seq, err
+	}
+
+	if len(chunk) > 0 {
+		if err := fn(chunk); err != nil {
+			return id, seq, err
+		}
+	}
+	return id, seq, nil
+}
+
+//Reset 全量同步之前清空所有数据,包括复制ID和序号,保留数据文件的格式标记.
+//全量同步完成之前复制ID为空、序号为0,不会被当作可以增量同步的从节点,重启之后生成新的复制ID.
+func (s *Storage) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		if iter.Key()[0] == KEY_PREFIX_LAYOUT {
+			continue
+		}
+		batch.Delete(append([]byte{}, iter.Key()...))
+		if batch.Len() >= RESET_BATCH {
+			if err := s.db.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := s.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	s.replID, s.seq = "", 0
+	s.prevID, s.prevSeq = "", 0
+	s.log.Reset(0)
+	return nil
+}
+
+//Load 全量同步时写入快照中的一批数据.
+func (s *Storage) Load(chunk []string) error {
+	if len(chunk)%2 != 0 {
+		return errors.New("快照数据错误")
+	}
+
+	batch := new(leveldb.Batch)
+	for i := 0; i < len(chunk); i += 2 {
+		batch.Put([]byte(chunk[i]), []byte(chunk[i+1]))
+	}
+	return s.db.Write(batch, nil)
+}
+
+//SetReplState 全量同步完成之后使用快照对应的复制ID和序号,清空复制日志.
+func (s *Storage) SetReplState(id string, seq uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	batch := new(leveldb.Batch)
+	batch.Put(replIDKey, []byte(id))
+	batch.Put(replSeqKey, encodeSeq(seq))
+	if err := s.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	s.replID, s.seq = id, seq
+	s.prevID, s.prevSeq = "", 0
+	s.log.Reset(seq)
+	return nil
+}
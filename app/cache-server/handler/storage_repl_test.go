@@ -0,0 +1,69 @@
+package handler
+
+import "testing"
+
+//TestReplicate 从节点全量同步快照之后,按序号写入复制日志,与主节点的数据一致.
+func TestReplicate(t *testing.T) {
+	master, done := newTestStorage(t)
+	defer done()
+	replica, done := newTestStorage(t)
+	defer done()
+
+	if err := master.Write("a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.HSet("h", []string{"f"}, []string{"v"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replica.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	id, seq, err := master.Snapshot(replica.Load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.SetReplState(id, seq); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.SetReadOnly(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := master.Write("b", "2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := master.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := master.ReplLog().Read(seq, 0)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("复制日志: %v %v", list, err)
+	}
+	if err := replica.Apply(list[1].Seq, list[1].Data); err != ErrReplSeq {
+		t.Fatalf("序号不连续: %v", err)
+	}
+	for _, entry := range list {
+		if err := replica.Apply(entry.Seq, entry.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := replica.Read("a"); err == nil {
+		t.Fatal("删除没有同步")
+	}
+	if val, err := replica.Read("b"); err != nil || val != "2" {
+		t.Fatalf("读取b: %q %v", val, err)
+	}
+	if val, err := replica.HGet("h", "f"); err != nil || val != "v" {
+		t.Fatalf("读取h: %q %v", val, err)
+	}
+	if err := replica.Write("c", "3", 0); err != ErrReadOnly {
+		t.Fatalf("从节点写入: %v", err)
+	}
+
+	rid, rseq := replica.ReplState()
+	if !master.CanContinue(rid, rseq) {
+		t.Fatalf("从节点不能增量同步: %s %d", rid, rseq)
+	}
+}
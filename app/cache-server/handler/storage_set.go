@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//set的元信息为8个字节的成员个数,每个成员单独存放: f + 插槽号 + key长度 + key + 成员,value为空.
+
+//SAdd 添加成员,返回新增的成员个数.
+func (s *Storage) SAdd(key string, members []string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_SET)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	fresh := m == nil
+	if fresh {
+		if m, err = s.create(batch, key, TYPE_SET); err != nil {
+			return 0, err
+		}
+	}
+
+	added := map[string]bool{}
+	for _, member := range members {
+		if added[member] {
+			continue
+		}
+
+		sub := subKey(key, []byte(member))
+		if !fresh {
+			ok, err := s.db.Has(sub, nil)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				continue
+			}
+		}
+		added[member] = true
+		batch.Put(sub, nil)
+	}
+
+	setMetaCount(m, metaCount(m)+len(added))
+	s.putMeta(batch, key, m)
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+	return len(added), nil
+}
+
+//SRem 删除成员,返回删除的成员个数,所有成员都被删除时同时删除key.
+func (s *Storage) SRem(key string, members []string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_SET)
+	if err != nil || m == nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	deleted := map[string]bool{}
+	for _, member := range members {
+		if deleted[member] {
+			continue
+		}
+
+		sub := subKey(key, []byte(member))
+		ok, err := s.db.Has(sub, nil)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		deleted[member] = true
+		batch.Delete(sub)
+	}
+
+	count := metaCount(m) - len(deleted)
+	if count > 0 {
+		setMetaCount(m, count)
+		s.putMeta(batch, key, m)
+	} else {
+		batch.Delete(encodeKey(key))
+	}
+
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+	return len(deleted), nil
+}
+
+//SMembers 获取所有成员.
+func (s *Storage) SMembers(key string) ([]string, error) {
+	list := []string{}
+	m, err := s.getTypedMeta(key, TYPE_SET)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	prefix := subPrefix(key)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		list = append(list, string(iter.Key()[len(prefix):]))
+	}
+
+	return list, iter.Error()
+}
+
+//SIsMember 判断是否为成员.
+func (s *Storage) SIsMember(key, member string) (bool, error) {
+	m, err := s.getTypedMeta(key, TYPE_SET)
+	if err != nil || m == nil {
+		return false, err
+	}
+
+	return s.db.Has(subKey(key, []byte(member)), nil)
+}
+
+//SCard 获取成员个数.
+func (s *Storage) SCard(key string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_SET)
+	if err != nil || m == nil {
+		return 0, err
+	}
+	return metaCount(m), nil
+}
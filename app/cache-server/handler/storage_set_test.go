@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/lib/packet"
+)
+
+//TestSet 重复的成员只添加一次,交集和并集按成员排序.
+func TestSet(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+	cache := &Cache{Storage: s, Lock: NewKeyLock(), MaxInflight: 1}
+
+	if n, err := s.SAdd("{s}a", []string{"x", "y", "z", "x"}); err != nil || n != 3 {
+		t.Fatalf("SADD: %d %v", n, err)
+	}
+	if n, err := s.SAdd("{s}b", []string{"y", "z", "w"}); err != nil || n != 3 {
+		t.Fatalf("SADD: %d %v", n, err)
+	}
+	if n, err := s.SRem("{s}b", []string{"w", "v"}); err != nil || n != 1 {
+		t.Fatalf("SREM: %d %v", n, err)
+	}
+	if n, err := s.SCard("{s}b"); err != nil || n != 2 {
+		t.Fatalf("SCARD: %d %v", n, err)
+	}
+	if ok, err := s.SIsMember("{s}a", "x"); err != nil || !ok {
+		t.Fatalf("SISMEMBER: %v %v", ok, err)
+	}
+
+	pkt := call(t, cache, cache.SInter, "{s}a", "{s}b")
+	if list, err := packet.DecodeArgs([]byte(pkt.Msg)); err != nil || !reflect.DeepEqual(list, []string{"y", "z"}) {
+		t.Fatalf("SINTER: %v %v", list, err)
+	}
+	pkt = call(t, cache, cache.SUnion, "{s}a", "{s}b")
+	if list, err := packet.DecodeArgs([]byte(pkt.Msg)); err != nil || !reflect.DeepEqual(list, []string{"x", "y", "z"}) {
+		t.Fatalf("SUNION: %v %v", list, err)
+	}
+}
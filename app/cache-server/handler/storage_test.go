@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//newTestStorage 在临时目录中创建存储,返回清理函数.
+func newTestStorage(t *testing.T) (*Storage, func()) {
+	dir, err := ioutil.TempDir("", "bigcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStorage(dir, NewReplLog(0)).(*Storage)
+	return s, func() {
+		s.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+//subCount 复合类型在数据文件中的元素个数.
+func subCount(t *testing.T, s *Storage, key string) int {
+	iter := s.db.NewIterator(util.BytesPrefix(subPrefix(key)), nil)
+	defer iter.Release()
+
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+//TestWriteOverExpiredHash 字符串覆盖已经过期但还未被清理的hash时,hash的元素一起删除.
+func TestWriteOverExpiredHash(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+
+	if _, err := s.HSet("h", []string{"a", "b"}, []string{"1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetExpireAt("h", Now()+10); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if err := s.Write("h", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := subCount(t, s, "h"); n != 0 {
+		t.Fatalf("残留的元素: %d", n)
+	}
+
+	val, err := s.Read("h")
+	if err != nil || val != "v" {
+		t.Fatalf("读取: %q %v", val, err)
+	}
+}
+
+//TestSyncTimeoutCommitted 同步复制等待从节点确认超时时,写入已经提交.
+func TestSyncTimeoutCommitted(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+	s.log.SyncReplicas = 1
+	s.log.SyncTimeout = 10 * time.Millisecond
+
+	if err := s.Write("k", "v", 0); err != ErrReplTimeout {
+		t.Fatalf("写入: %v", err)
+	}
+
+	val, err := s.Read("k")
+	if err != nil || val != "v" {
+		t.Fatalf("读取: %q %v", val, err)
+	}
+}
+
+//TestUpgradeLayout 旧版本直接保存key的数据文件转换为当前的格式.
+func TestUpgradeLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bigcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("foo"), []byte("bar"), nil); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s := NewStorage(path, NewReplLog(0)).(*Storage)
+	defer s.db.Close()
+	val, err := s.Read("foo")
+	if err != nil || val != "bar" {
+		t.Fatalf("读取: %q %v", val, err)
+	}
+	if _, err := os.Stat(path + ".v0"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//TestLayoutSlotCount 插槽数量改变之后不能使用原来的数据文件.
+func TestLayoutSlotCount(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+	defer utils.SetSlotCount(utils.SlotCount())
+
+	utils.SetSlotCount(utils.SlotCount() / 2)
+	if err := s.checkLayout(); !errors.Is(err, ErrLayout) {
+		t.Fatalf("插槽数量改变: %v", err)
+	}
+}
+
+//TestSlotRange 按插槽遍历key,从start开始按key的顺序返回.
+func TestSlotRange(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+
+	for _, key := range []string{"{t}c", "{t}a", "{t}b", "other"} {
+		if err := s.Write(key, "v", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	slot := utils.Slot("{t}")
+	var keys []string
+	err := s.SlotRange(slot, "{t}b", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "{t}b" || keys[1] != "{t}c" {
+		t.Fatalf("遍历结果: %v", keys)
+	}
+
+	if n, err := s.SlotCount(slot); err != nil || n != 3 {
+		t.Fatalf("插槽数据条数: %d %v", n, err)
+	}
+}
+
+//TestExpireSweep 过期的key读取不到,清理时只删除过期时间没有被修改过的数据.
+func TestExpireSweep(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+
+	past, future := Now()-1, Now()+time.Hour.Milliseconds()
+	if err := s.Write("a", "1", past); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("b", "2", future); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("c", "3", past); err != nil {
+		t.Fatal(err)
+	}
+	//c重新写入之后不再过期,旧的索引仍然存在.
+	if err := s.Write("c", "3", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Read("a"); err != leveldb.ErrNotFound {
+		t.Fatalf("读取过期的key: %v", err)
+	}
+
+	list, err := s.ExpiredKeys(Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("过期索引: %+v", list)
+	}
+	for _, index := range list {
+		if err := s.DeleteExpired(index); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.db.Get(encodeKey("a"), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("过期的key没有被删除: %v", err)
+	}
+	if val, err := s.Read("c"); err != nil || val != "3" {
+		t.Fatalf("读取c: %q %v", val, err)
+	}
+	if expireAt, err := s.ExpireAt("b"); err != nil || expireAt != future {
+		t.Fatalf("b的过期时间: %d %v", expireAt, err)
+	}
+	if list, _ := s.ExpiredKeys(Now(), 10); len(list) != 0 {
+		t.Fatalf("剩余的过期索引: %+v", list)
+	}
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//zset的元信息为8个字节的成员个数,每个成员存放两条记录:
+//成员到分数的映射: f + 插槽号 + key长度 + key + m + 成员,value为分数.
+//按分数排序的索引: f + 插槽号 + key长度 + key + s + 分数 + 成员,value为空.
+const (
+	ZSET_MEMBER = 'm'
+	ZSET_SCORE  = 's'
+)
+
+//ZMember zset的成员及分数.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+//encodeScore 将分数编码为按字节序排序与数值大小一致的8个字节.
+func encodeScore(score float64) []byte {
+	//-0与0视为相同的分数.
+	if score == 0 {
+		score = 0
+	}
+
+	bits := math.Float64bits(score)
+	if bits>>63 == 1 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+//decodeScore 解析encodeScore编码的分数.
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+//zmemberKey 成员到分数的映射的key.
+func zmemberKey(key, member string) []byte {
+	return subKey(key, append([]byte{ZSET_MEMBER}, member...))
+}
+
+//zscoreKey 分数索引的key.
+func zscoreKey(key string, score float64, member string) []byte {
+	sub := append([]byte{ZSET_SCORE}, encodeScore(score)...)
+	return subKey(key, append(sub, member...))
+}
+
+//zscorePrefix 分数索引的前缀.
+func zscorePrefix(key string) []byte {
+	return subKey(key, []byte{ZSET_SCORE})
+}
+
+//decodeZMember 解析分数索引的key.
+func decodeZMember(prefix, index []byte) ZMember {
+	index = index[len(prefix):]
+	return ZMember{
+		Member: string(index[8:]),
+		Score:  decodeScore(index[:8]),
+	}
+}
+
+//zscore 读取成员的分数.
+func (s *Storage) zscore(key, member string) (float64, error) {
+	buf, err := s.db.Get(zmemberKey(key, member), nil)
+	if err != nil {
+		return 0, err
+	}
+	return decodeScore(buf), nil
+}
+
+//ZAdd 添加成员或更新成员的分数,返回新增的成员个数.
+func (s *Storage) ZAdd(key string, members []ZMember) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_ZSET)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	fresh := m == nil
+	if fresh {
+		if m, err = s.create(batch, key, TYPE_ZSET); err != nil {
+			return 0, err
+		}
+	}
+
+	//同一个成员出现多次时以最后一次的分数为准.
+	scores := map[string]float64{}
+	added := 0
+	for _, item := range members {
+		old, ok := scores[item.Member]
+		if !ok && !fresh {
+			old, err = s.zscore(key, item.Member)
+			if err != nil && err != leveldb.ErrNotFound {
+				return 0, err
+			}
+			ok = err == nil
+		}
+
+		if ok {
+			batch.Delete(zscoreKey(key, old, item.Member))
+		} else {
+			added++
+		}
+
+		scores[item.Member] = item.Score
+		batch.Put(zmemberKey(key, item.Member), encodeScore(item.Score))
+		batch.Put(zscoreKey(key, item.Score, item.Member), nil)
+	}
+
+	setMetaCount(m, metaCount(m)+added)
+	s.putMeta(batch, key, m)
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+//ZScore 读取成员的分数.
+func (s *Storage) ZScore(key, member string) (float64, error) {
+	m, err := s.getTypedMeta(key, TYPE_ZSET)
+	if err != nil {
+		return 0, err
+	}
+	if m == nil {
+		return 0, leveldb.ErrNotFound
+	}
+
+	return s.zscore(key, member)
+}
+
+//ZRem 删除成员,返回删除的成员个数,所有成员都被删除时同时删除key.
+func (s *Storage) ZRem(key string, members []string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_ZSET)
+	if err != nil || m == nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	deleted := map[string]bool{}
+	for _, member := range members {
+		if deleted[member] {
+			continue
+		}
+
+		score, err := s.zscore(key, member)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		deleted[member] = true
+		batch.Delete(zmemberKey(key, member))
+		batch.Delete(zscoreKey(key, score, member))
+	}
+
+	count := metaCount(m) - len(deleted)
+	if count > 0 {
+		setMetaCount(m, count)
+		s.putMeta(batch, key, m)
+	} else {
+		batch.Delete(encodeKey(key))
+	}
+
+	if err := s.commit(batch); err != nil {
+		return 0, err
+	}
+	return len(deleted), nil
+}
+
+//ZCard 获取成员个数.
+func (s *Storage) ZCard(key string) (int, error) {
+	m, err := s.getTypedMeta(key, TYPE_ZSET)
+	if err != nil || m == nil {
+		return 0, err
+	}
+	return metaCount(m), nil
+}
+
+//ZRange 按分数从小到大获取排名在[start, stop]之间的成员,负数表示从尾部开始.
+func (s *Storage) ZRange(key string, start, stop int) ([]ZMember, error) {
+	list := []ZMember{}
+	m, err := s.getTypedMeta(key, TYPE_ZSET)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	start, stop, ok := listIndex(start, stop, metaCount(m))
+	if !ok {
+		return list, nil
+	}
+
+	prefix := zscorePrefix(key)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for i := 0; i <= stop && iter.Next(); i++ {
+		if i < start {
+			continue
+		}
+		list = append(list, decodeZMember(prefix, iter.Key()))
+	}
+
+	return list, iter.Error()
+}
+
+//ZRangeByScore 按分数从小到大获取分数在[min, max]之间的成员,minEx、maxEx为true时不包含边界.
+//跳过前offset个成员,最多返回count个成员,count小于0时不限制.
+func (s *Storage) ZRangeByScore(key string, min, max float64, minEx, maxEx bool, offset, count int) ([]ZMember, error) {
+	list := []ZMember{}
+	m, err := s.getTypedMeta(key, TYPE_ZSET)
+	if err != nil || m == nil {
+		return list, err
+	}
+
+	prefix := zscorePrefix(key)
+	rng := util.BytesPrefix(prefix)
+	rng.Start = append(prefix, encodeScore(min)...)
+
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+	for count != 0 && iter.Next() {
+		item := decodeZMember(prefix, iter.Key())
+		if minEx && item.Score == min {
+			continue
+		}
+		if item.Score > max || (maxEx && item.Score == max) {
+			break
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		list = append(list, item)
+		count--
+	}
+
+	return list, iter.Error()
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+//zmembers 成员名称列表.
+func zmembers(list []ZMember) []string {
+	names := make([]string, len(list))
+	for i, item := range list {
+		names[i] = item.Member
+	}
+	return names
+}
+
+//TestZSetOrder 按分数排序,分数相同时按成员排序,更新分数之后移动到新的位置.
+func TestZSetOrder(t *testing.T) {
+	s, done := newTestStorage(t)
+	defer done()
+
+	members := []ZMember{{"a", 3}, {"b", -1.5}, {"c", 0}, {"d", 3}, {"e", 100}}
+	if n, err := s.ZAdd("z", members); err != nil || n != 5 {
+		t.Fatalf("ZADD: %d %v", n, err)
+	}
+	list, err := s.ZRange("z", 0, -1)
+	if err != nil || !reflect.DeepEqual(zmembers(list), []string{"b", "c", "a", "d", "e"}) {
+		t.Fatalf("ZRANGE: %v %v", list, err)
+	}
+
+	if n, err := s.ZAdd("z", []ZMember{{"e", -10}}); err != nil || n != 0 {
+		t.Fatalf("更新分数: %d %v", n, err)
+	}
+	if list, _ := s.ZRange("z", 0, 1); !reflect.DeepEqual(zmembers(list), []string{"e", "b"}) {
+		t.Fatalf("更新分数之后: %v", list)
+	}
+	if score, err := s.ZScore("z", "e"); err != nil || score != -10 {
+		t.Fatalf("ZSCORE: %v %v", score, err)
+	}
+
+	list, err = s.ZRangeByScore("z", 0, 3, true, false, 0, -1)
+	if err != nil || !reflect.DeepEqual(zmembers(list), []string{"a", "d"}) {
+		t.Fatalf("ZRANGEBYSCORE (0 3: %v %v", list, err)
+	}
+	list, _ = s.ZRangeByScore("z", math.Inf(-1), math.Inf(1), false, false, 1, 2)
+	if !reflect.DeepEqual(zmembers(list), []string{"b", "c"}) {
+		t.Fatalf("ZRANGEBYSCORE LIMIT 1 2: %v", list)
+	}
+
+	if n, err := s.ZRem("z", []string{"a", "x"}); err != nil || n != 1 {
+		t.Fatalf("ZREM: %d %v", n, err)
+	}
+	if n, err := s.ZCard("z"); err != nil || n != 4 {
+		t.Fatalf("ZCARD: %d %v", n, err)
+	}
+	if n := subCount(t, s, "z"); n != 8 {
+		t.Fatalf("成员及分数索引: %d", n)
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//按参数中key的位置区分的协议.
+const (
+	KEY_FIRST = 1 //第一个参数为key.
+	KEY_ALL   = 2 //所有参数都是key.
+	KEY_PAIRS = 3 //参数为key、value交替.
+)
+
+//keyProtocols 操作key的协议及key在参数中的位置,协商了CAP_EPOCH时检查key所在的插槽是否属于当前节点.
+var keyProtocols = map[packet.BigcacheProtocol]int{
+	packet.READ:            KEY_FIRST,
+	packet.WRITE:           KEY_FIRST,
+	packet.DELETE:          KEY_ALL,
+	packet.MIGRATE_WRITE:   KEY_FIRST,
+	packet.DUMP:            KEY_FIRST,
+	packet.MIGRATE_KEY:     KEY_FIRST,
+	packet.EXPIRE:          KEY_FIRST,
+	packet.EXPIRE_AT:       KEY_FIRST,
+	packet.TTL:             KEY_FIRST,
+	packet.PERSIST:         KEY_FIRST,
+	packet.INCR:            KEY_FIRST,
+	packet.INCR_FLOAT:      KEY_FIRST,
+	packet.HSET:            KEY_FIRST,
+	packet.HGET:            KEY_FIRST,
+	packet.HMGET:           KEY_FIRST,
+	packet.HGETALL:         KEY_FIRST,
+	packet.HDEL:            KEY_FIRST,
+	packet.HINCRBY:         KEY_FIRST,
+	packet.HLEN:            KEY_FIRST,
+	packet.LPUSH:           KEY_FIRST,
+	packet.RPUSH:           KEY_FIRST,
+	packet.LPOP:            KEY_FIRST,
+	packet.RPOP:            KEY_FIRST,
+	packet.LRANGE:          KEY_FIRST,
+	packet.LLEN:            KEY_FIRST,
+	packet.LTRIM:           KEY_FIRST,
+	packet.SADD:            KEY_FIRST,
+	packet.SREM:            KEY_FIRST,
+	packet.SMEMBERS:        KEY_FIRST,
+	packet.SISMEMBER:       KEY_FIRST,
+	packet.SCARD:           KEY_FIRST,
+	packet.SINTER:          KEY_ALL,
+	packet.SUNION:          KEY_ALL,
+	packet.ZADD:            KEY_FIRST,
+	packet.ZRANGE:          KEY_FIRST,
+	packet.ZRANGE_BY_SCORE: KEY_FIRST,
+	packet.ZREM:            KEY_FIRST,
+	packet.ZSCORE:          KEY_FIRST,
+	packet.ZINCRBY:         KEY_FIRST,
+	packet.ZCARD:           KEY_FIRST,
+	packet.MREAD:           KEY_ALL,
+	packet.MWRITE:          KEY_PAIRS,
+}
+
+//Topology 当前节点已知的插槽路由表,用于发现proxy的路由表已经过期.
+type Topology struct {
+	lock  *sync.RWMutex
+	slots map[int]base.Slot
+}
+
+//loadTopology 加载所有插槽并监听之后的变化,加载失败时一直重试.
+func loadTopology(reg registry.Registry) *Topology {
+	t := &Topology{lock: &sync.RWMutex{}, slots: make(map[int]base.Slot)}
+
+	list, rev, err := reg.Slots()
+	for err != nil {
+		log.Printf("err:%+v\n", err)
+		time.Sleep(time.Second)
+		list, rev, err = reg.Slots()
+	}
+	t.replace(list)
+
+	go func() {
+		for ev := range reg.WatchSlots(rev) {
+			t.update(ev)
+		}
+	}()
+	return t
+}
+
+//replace 用list替换整个路由表.
+func (t *Topology) replace(list []base.Slot) {
+	slots := make(map[int]base.Slot, len(list))
+	for _, slot := range list {
+		slots[slot.ID] = slot
+	}
+
+	t.lock.Lock()
+	t.slots = slots
+	t.lock.Unlock()
+}
+
+//update 根据插槽的变化更新路由表,忽略epoch较小的变化.
+func (t *Topology) update(ev registry.SlotEvent) {
+	if ev.Type == registry.EVENT_RELOAD {
+		t.replace(ev.Slots)
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if old, ok := t.slots[ev.Slot.ID]; ok && old.Epoch > ev.Slot.Epoch {
+		return
+	}
+	if ev.Type == registry.EVENT_DELETE {
+		delete(t.slots, ev.Slot.ID)
+		return
+	}
+	t.slots[ev.Slot.ID] = ev.Slot
+}
+
+//get 获取一个插槽.
+func (t *Topology) get(id int) (base.Slot, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	slot, ok := t.slots[id]
+	return slot, ok
+}
+
+//topologyRegistry 用于监听插槽的注册中心,没有配置注册中心时返回nil.
+func (cache *Cache) topologyRegistry() registry.Registry {
+	if cache.Registry != nil {
+		return cache.Registry
+	}
+	if cache.Etcd != nil {
+		return registry.NewEtcdWithClient(cache.Etcd)
+	}
+	return nil
+}
+
+//owns 插槽是否属于当前节点,迁移状态的插槽属于新节点.
+func (cache *Cache) owns(slot base.Slot) bool {
+	if slot.Types == base.SLOT_TYPE_MIGRATE {
+		return slot.NewIP == cache.Addr
+	}
+	return slot.IP == cache.Addr
+}
+
+//checkEpoch 检查请求的key所在的插槽是否属于当前节点.
+//当前节点已知的插槽epoch大于请求中的epoch,并且插槽已经不属于当前节点时,返回MOVED,proxy刷新路由表后重试.
+//当前节点的路由表比proxy旧时以proxy为准.
+func (cache *Cache) checkEpoch(pkt packet.Request, epoch uint64, cli *Client) bool {
+	pos, ok := keyProtocols[pkt.Protocol]
+	if !ok || epoch == 0 || cache.Topology == nil {
+		return true
+	}
+
+	//只按长度前缀找到key的位置,参数由具体的协议解析,避免同一个请求解析两次.
+	step := 1
+	if pos == KEY_PAIRS {
+		step = 2
+	}
+
+	var moved *base.Slot
+	packet.WalkArgs(pkt.Body, func(i int, arg []byte) bool {
+		if i%step != 0 {
+			return true
+		}
+		slot, ok := cache.Topology.get(int(utils.Slot(string(arg))))
+		if ok && slot.Epoch > epoch && !cache.owns(slot) {
+			moved = &slot
+			return false
+		}
+		return pos != KEY_FIRST
+	})
+	//没有需要转移的key,参数错误由具体的协议返回.
+	if moved == nil {
+		return true
+	}
+
+	ip := moved.IP
+	if moved.Types == base.SLOT_TYPE_MIGRATE {
+		ip = moved.NewIP
+	}
+	cli.Write(packet.Moved(moved.ID, ip, moved.Epoch), errcode.MOVED)
+	return false
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/errcode"
+	"github.com/houzhongjian/bigcache/lib/packet"
+	"github.com/houzhongjian/bigcache/lib/registry"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//TestTopologyUpdate 忽略epoch小于当前路由表的插槽变化.
+func TestTopologyUpdate(t *testing.T) {
+	topo := &Topology{lock: &sync.RWMutex{}, slots: make(map[int]base.Slot)}
+	topo.replace([]base.Slot{{ID: 1, IP: "a", Epoch: 5}})
+
+	topo.update(registry.SlotEvent{Type: registry.EVENT_PUT, Slot: base.Slot{ID: 1, IP: "b", Epoch: 4}})
+	if slot, _ := topo.get(1); slot.IP != "a" {
+		t.Fatalf("旧的变化覆盖了路由表: %+v", slot)
+	}
+	topo.update(registry.SlotEvent{Type: registry.EVENT_PUT, Slot: base.Slot{ID: 1, IP: "c", Epoch: 6}})
+	if slot, _ := topo.get(1); slot.IP != "c" {
+		t.Fatalf("新的变化没有生效: %+v", slot)
+	}
+}
+
+//TestCheckEpoch proxy的路由表比当前节点旧且插槽已经不属于当前节点时返回MOVED.
+func TestCheckEpoch(t *testing.T) {
+	id := int(utils.Slot("k"))
+	topo := &Topology{lock: &sync.RWMutex{}, slots: make(map[int]base.Slot)}
+	topo.replace([]base.Slot{{ID: id, IP: "other", Epoch: 5}})
+	cache := &Cache{Addr: "self", Topology: topo, MaxInflight: 1}
+	pkt := packet.Request{Protocol: packet.READ, Body: packet.EncodeArgs("k")}
+
+	for _, epoch := range []uint64{0, 5, 6} {
+		if !cache.checkEpoch(pkt, epoch, nil) {
+			t.Fatalf("epoch %d 不应该返回MOVED", epoch)
+		}
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		if cache.checkEpoch(pkt, 4, cache.NewClient(server)) {
+			t.Error("epoch 4 应该返回MOVED")
+		}
+		server.Close()
+	}()
+	resp, err := packet.ParseResponse(client)
+	if err != nil || resp.Err != errcode.MOVED || resp.Msg != packet.Moved(id, "other", 5) {
+		t.Fatalf("MOVED: %+v %v", resp, err)
+	}
+}
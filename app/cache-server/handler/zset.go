@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//ZAdd 添加zset成员,请求参数为key、score、member、score、member...,返回新增的成员个数.
+func (cache *Cache) ZAdd(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	if len(content)%2 == 0 {
+		cli.Write("参数错误", errcode.INFO)
+		return
+	}
+
+	key := content[0]
+	members := make([]ZMember, 0, len(content)/2)
+	for i := 1; i < len(content); i += 2 {
+		score, err := parseScore(content[i])
+		if err != nil {
+			cli.Write(errcode.MSG_NOT_FLOAT, errcode.INFO)
+			return
+		}
+		members = append(members, ZMember{Member: content[i+1], Score: score})
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.ZAdd(key, members)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//ZScore 读取zset成员的分数,请求参数为key、member.
+func (cache *Cache) ZScore(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	score, err := cache.Storage.ZScore(content[0], content[1])
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			cli.Write(err.Error(), errcode.NOT_FOUND)
+			return
+		}
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(formatScore(score), errcode.NO_ERROR)
+}
+
+//ZIncrBy 原子增加zset成员的分数,请求参数为key、增量、member,返回增加之后的分数.
+//成员不存在时按0处理.
+func (cache *Cache) ZIncrBy(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	member := content[2]
+	delta, err := parseScore(content[1])
+	if err != nil {
+		cli.Write(errcode.MSG_NOT_FLOAT, errcode.INFO)
+		return
+	}
+
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	score, err := cache.Storage.ZScore(key, member)
+	if err != nil && err != leveldb.ErrNotFound {
+		cli.WriteError(err)
+		return
+	}
+
+	score += delta
+	if math.IsNaN(score) {
+		cli.Write(errcode.MSG_SCORE_NAN, errcode.INFO)
+		return
+	}
+
+	if _, err := cache.Storage.ZAdd(key, []ZMember{{Member: member, Score: score}}); err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(formatScore(score), errcode.NO_ERROR)
+}
+
+//ZRem 删除zset成员,请求参数为key、member...,返回删除的成员个数.
+func (cache *Cache) ZRem(body []byte, cli *Client) {
+	content, ok := cache.args(body, 2, cli)
+	if !ok {
+		return
+	}
+
+	key := content[0]
+	cache.Lock.Lock(key)
+	defer cache.Lock.Unlock(key)
+
+	n, err := cache.Storage.ZRem(key, content[1:])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//ZCard 获取zset的成员个数.
+func (cache *Cache) ZCard(body []byte, cli *Client) {
+	content, ok := cache.args(body, 1, cli)
+	if !ok {
+		return
+	}
+
+	n, err := cache.Storage.ZCard(content[0])
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.Write(strconv.Itoa(n), errcode.NO_ERROR)
+}
+
+//ZRange 按排名获取zset成员,请求参数为key、start、stop,返回列表,成员和分数交替排列.
+func (cache *Cache) ZRange(body []byte, cli *Client) {
+	content, ok := cache.args(body, 3, cli)
+	if !ok {
+		return
+	}
+
+	start, stop, ok := cache.indexArgs(content[1:], cli)
+	if !ok {
+		return
+	}
+
+	list, err := cache.Storage.ZRange(content[0], start, stop)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(zmemberList(list))
+}
+
+//ZRangeByScore 按分数获取zset成员,请求参数为key、min、max、offset、count,返回列表,成员和分数交替排列.
+//min、max以(开头时表示不包含边界,支持-inf、+inf,count小于0时不限制数量.
+func (cache *Cache) ZRangeByScore(body []byte, cli *Client) {
+	content, ok := cache.args(body, 5, cli)
+	if !ok {
+		return
+	}
+
+	min, minEx, err := parseScoreBound(content[1])
+	if err != nil {
+		cli.Write(errcode.MSG_MIN_MAX_NOT_FLOAT, errcode.INFO)
+		return
+	}
+	max, maxEx, err := parseScoreBound(content[2])
+	if err != nil {
+		cli.Write(errcode.MSG_MIN_MAX_NOT_FLOAT, errcode.INFO)
+		return
+	}
+
+	offset, count, ok := cache.indexArgs(content[3:], cli)
+	if !ok {
+		return
+	}
+
+	list, err := cache.Storage.ZRangeByScore(content[0], min, max, minEx, maxEx, offset, count)
+	if err != nil {
+		cli.WriteError(err)
+		return
+	}
+
+	cli.WriteList(zmemberList(list))
+}
+
+//zmemberList 将成员列表转换为成员和分数交替排列的列表.
+func zmemberList(list []ZMember) []string {
+	result := make([]string, 0, len(list)*2)
+	for _, item := range list {
+		result = append(result, item.Member, formatScore(item.Score))
+	}
+	return result
+}
+
+//parseScore 解析分数,不允许NaN.
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(score) {
+		return 0, strconv.ErrSyntax
+	}
+	return score, nil
+}
+
+//parseScoreBound 解析分数区间的边界,以(开头时表示不包含边界.
+func parseScoreBound(s string) (score float64, exclusive bool, err error) {
+	if strings.HasPrefix(s, "(") {
+		exclusive = true
+		s = s[1:]
+	}
+
+	score, err = parseScore(s)
+	return score, exclusive, err
+}
+
+//formatScore 格式化分数,与redis一致,无穷大返回inf、-inf.
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
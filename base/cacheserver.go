@@ -14,9 +14,25 @@ type CacheServer struct {
 	Types    CacheServerType
 	TypeName string `json:"-"`
 	IP       string //对应的ip地址.
+	Master   string //主节点的ip地址,为空时为主节点.
+	Capacity int    //节点容量,单位MB,0表示不限制.
+	Version  string //cache server的版本号.
+	Seq      string `json:"-"` //复制序号.
+	Lag      string `json:"-"` //复制延迟.
 	// Slot     [2]int //插槽范围.
 }
 
+//ReplicasOf 获取主节点的所有从节点ip地址.
+func ReplicasOf(list []CacheServer, master string) []string {
+	var replicas []string
+	for _, cacheServer := range list {
+		if cacheServer.Master == master && len(master) > 0 {
+			replicas = append(replicas, cacheServer.IP)
+		}
+	}
+	return replicas
+}
+
 func SwitchCacheServerType(types CacheServerType) string {
 	var name string
 	switch types {
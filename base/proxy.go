@@ -0,0 +1,8 @@
+package base
+
+//ProxyServer proxy在etcd中的注册信息.
+type ProxyServer struct {
+	Addr    string //客户端连接的地址.
+	Clients int64  //当前连接的客户端数量.
+	Version string //proxy的版本号.
+}
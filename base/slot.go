@@ -1,9 +1,5 @@
 package base
 
-import (
-	"net"
-)
-
 //插槽类型.
 type SlotType uint
 
@@ -18,8 +14,8 @@ type Slot struct {
 	TypeName string   `json:"-"`
 	IP       string   //插槽对应的ip地址.
 	NewIP    string   //当插槽处于迁移状态的时候，当前属性才会有值.
-	Conn     net.Conn `json:"-"`
-	NewConn  net.Conn `json:"-"`
+	Replicas []string //插槽所在节点的从节点ip地址.
+	Epoch    uint64   `json:"-"` //插槽最后一次修改时的epoch,由注册中心在读取时填写,每次修改都会增大.
 }
 
 func SwitchSlotType(types SlotType) string {
@@ -0,0 +1,4 @@
+package base
+
+//VERSION bigcache的版本号,cache server和proxy注册时写入etcd.
+const VERSION = "1.1.0"
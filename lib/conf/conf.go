@@ -33,7 +33,7 @@ func Load(path string) {
 		}
 		
 		//按照=来拆分配置.
-		arr := strings.Split(line, "=")
+		arr := strings.SplitN(line, "=", 2)
 		key := strings.Trim(arr[0], " ")
 		val := strings.Trim(arr[1], " ")
 		cf[key] = val
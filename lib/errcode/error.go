@@ -3,7 +3,26 @@ package errcode
 type BigcacheError int
 
 const (
-	NO_ERROR  BigcacheError = 1000 //没有错误.
-	INFO      BigcacheError = 1001 //普通错误.
-	NOT_FOUND BigcacheError = 1002 //数据不存在.
+	NO_ERROR       BigcacheError = 1000 //没有错误.
+	INFO           BigcacheError = 1001 //普通错误.
+	NOT_FOUND      BigcacheError = 1002 //数据不存在.
+	NOT_SET        BigcacheError = 1003 //条件不满足,未执行写入.
+	AUTH_FAILED    BigcacheError = 1004 //连接授权失败,连接会被关闭.
+	PROTOCOL_ERROR BigcacheError = 1005 //数据包格式错误,连接会被关闭.
+	MOVED          BigcacheError = 1006 //发送方的路由表已过期,插槽不再属于当前节点,刷新路由表后重试.
+	NOT_ACKED      BigcacheError = 1007 //写入已经在主节点提交,但同步复制时没有足够的从节点在超时之前确认,之后仍会复制到从节点,不能作为写入失败重试.
+)
+
+//与redis兼容的错误信息.
+const (
+	MSG_NOT_INTEGER       = "ERR value is not an integer or out of range"
+	MSG_NOT_FLOAT         = "ERR value is not a valid float"
+	MSG_OVERFLOW          = "ERR increment or decrement would overflow"
+	MSG_NAN_OR_INF        = "ERR increment would produce NaN or Infinity"
+	MSG_WRONG_TYPE        = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	MSG_HASH_NOT_INTEGER  = "ERR hash value is not an integer"
+	MSG_SCORE_NAN         = "ERR resulting score is not a number (NaN)"
+	MSG_MIN_MAX_NOT_FLOAT = "ERR min or max is not a float"
+	MSG_READONLY          = "READONLY You can't write against a read only replica."
+	MSG_NOT_ACKED         = "NOACK write committed on master but not acknowledged by enough replicas"
 )
@@ -1,13 +1,30 @@
 package etcd
 
 import (
+	"context"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"go.etcd.io/etcd/clientv3"
 )
 
+//SLOT_COUNT_KEY 插槽数量在etcd中的key.
+const SLOT_COUNT_KEY = "/cluster/slotcount"
+
+//ALIVE_PREFIX cache server存活状态的key前缀,绑定租约,节点停止心跳之后自动删除.
+const ALIVE_PREFIX = "/alive/"
+
+//PROXY_PREFIX proxy注册信息的key前缀,绑定租约,proxy停止心跳之后自动删除.
+const PROXY_PREFIX = "/proxy/"
+
+//FAILOVER_PREFIX 主节点下线之后选举新主节点使用的key前缀.
+const FAILOVER_PREFIX = "/failover/"
+
+//DEFAULT_MAX_TXN_OPS etcd单个事务中默认允许的最大操作数.
+const DEFAULT_MAX_TXN_OPS = 128
+
 func New(addr string) *clientv3.Client {
 	sarr := strings.Split(addr, ",")
 	cli, err := clientv3.New(clientv3.Config{
@@ -22,3 +39,38 @@ func New(addr string) *clientv3.Client {
 
 	return cli
 }
+
+//LoadSlotCount 从etcd中获取集群的插槽数量.
+//etcd中不存在时写入count,已存在时以etcd中的为准,保证proxy、admin、server使用相同的插槽数量.
+func LoadSlotCount(cli *clientv3.Client, count uint32) (uint32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(SLOT_COUNT_KEY), "=", 0)).
+		Then(clientv3.OpPut(SLOT_COUNT_KEY, strconv.Itoa(int(count)))).
+		Else(clientv3.OpGet(SLOT_COUNT_KEY)).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Succeeded {
+		return count, nil
+	}
+
+	kvs := resp.Responses[0].GetResponseRange().Kvs
+	if len(kvs) < 1 {
+		return count, nil
+	}
+
+	n, err := strconv.Atoi(string(kvs[0].Value))
+	if err != nil {
+		return 0, err
+	}
+
+	if uint32(n) != count {
+		log.Println("配置的插槽数量:", count, "与集群的插槽数量:", n, "不一致, 使用集群的插槽数量")
+	}
+	return uint32(n), nil
+}
@@ -0,0 +1,242 @@
+package gossip
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+//State 成员的状态.
+type State int
+
+const (
+	STATE_ALIVE   State = 1 //存活.
+	STATE_SUSPECT State = 2 //疑似下线,超时之后确认下线.
+	STATE_DEAD    State = 3 //下线.
+)
+
+//默认配置.
+const (
+	DEFAULT_PROBE_INTERVAL     = time.Second
+	DEFAULT_PROBE_TIMEOUT      = 500 * time.Millisecond
+	DEFAULT_SUSPECT_TIMEOUT    = 5 * time.Second
+	DEFAULT_PUSH_PULL_INTERVAL = 30 * time.Second
+	DEFAULT_INDIRECT_CHECKS    = 3
+)
+
+//DEAD_RETAIN 下线的成员保留的时间,之后从成员列表中删除.
+const DEAD_RETAIN = time.Hour
+
+var ErrClosed = errors.New("gossip已关闭")
+
+//Member 集群中的一个成员.
+type Member struct {
+	Name        string            //gossip地址,唯一标识一个成员.
+	Incarnation uint64            //成员自己维护的版本号,反驳疑似下线或者更新Meta时增加.
+	State       State             //成员状态.
+	Meta        map[string]string //成员的注册信息.
+	since       time.Time         //进入当前状态的时间.
+}
+
+//Config gossip的配置.
+type Config struct {
+	Addr             string        //监听的地址,udp用于探测,tcp用于同步全部状态.
+	Seeds            []string      //加入集群时连接的成员地址.
+	Secret           string        //消息签名的密钥,为空时不签名.
+	ProbeInterval    time.Duration //探测的间隔.
+	ProbeTimeout     time.Duration //等待探测回复的时间,超时之后请求其他成员间接探测.
+	SuspectTimeout   time.Duration //疑似下线之后确认下线的时间.
+	PushPullInterval time.Duration //与随机成员同步全部状态的间隔.
+	IndirectChecks   int           //间接探测的成员数量.
+}
+
+//Gossip SWIM协议的成员管理及集群状态的传播.
+//成员通过定时探测发现下线,成员变化附带在探测消息中传播.
+//集群状态为带版本号的key/value,版本号较大的覆盖较小的,探测时发现状态摘要不一致则通过tcp同步全部状态.
+type Gossip struct {
+	cfg     Config
+	lock    *sync.Mutex
+	self    *Member
+	members map[string]*Member
+	probes  []string //本轮探测的顺序.
+	queue   []*broadcast
+	seq     uint32
+	acks    map[uint32]chan struct{}
+	store   *store
+
+	udp     *net.UDPConn
+	tcp     net.Listener
+	syncing map[string]bool //正在同步全部状态的成员.
+	stop    chan struct{}
+}
+
+//New 创建gossip并开始监听,之后调用Join加入集群.
+func New(cfg Config) (*Gossip, error) {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = DEFAULT_PROBE_INTERVAL
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = DEFAULT_PROBE_TIMEOUT
+	}
+	if cfg.ProbeTimeout >= cfg.ProbeInterval {
+		cfg.ProbeTimeout = cfg.ProbeInterval / 2
+	}
+	if cfg.SuspectTimeout <= 0 {
+		cfg.SuspectTimeout = DEFAULT_SUSPECT_TIMEOUT
+	}
+	if cfg.PushPullInterval <= 0 {
+		cfg.PushPullInterval = DEFAULT_PUSH_PULL_INTERVAL
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = DEFAULT_INDIRECT_CHECKS
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp4", cfg.Addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	self := &Member{Name: cfg.Addr, Incarnation: 1, State: STATE_ALIVE, Meta: map[string]string{}, since: time.Now()}
+	g := &Gossip{
+		cfg:     cfg,
+		lock:    &sync.Mutex{},
+		self:    self,
+		members: map[string]*Member{self.Name: self},
+		acks:    make(map[uint32]chan struct{}),
+		store:   newStore(cfg.Addr),
+		udp:     udp,
+		tcp:     tcp,
+		syncing: make(map[string]bool),
+		stop:    make(chan struct{}),
+	}
+
+	go g.readUDP()
+	go g.acceptTCP()
+	go g.probeLoop()
+	go g.pushPullLoop()
+	return g, nil
+}
+
+//Join 与种子成员同步全部状态,所有种子都无法连接时作为第一个成员启动.
+func (g *Gossip) Join() error {
+	joined := 0
+	for _, seed := range g.cfg.Seeds {
+		if seed == g.cfg.Addr {
+			continue
+		}
+		if err := g.pushPull(seed); err != nil {
+			log.Println("连接种子成员失败:", seed, err)
+			continue
+		}
+		joined++
+	}
+
+	if joined == 0 && len(g.cfg.Seeds) > 0 {
+		log.Println("没有可以连接的种子成员, 作为第一个成员启动")
+	}
+	return nil
+}
+
+//Name 当前成员的名称.
+func (g *Gossip) Name() string {
+	return g.cfg.Addr
+}
+
+//Members 获取所有的成员,包括疑似下线和下线的成员.
+func (g *Gossip) Members() []Member {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	list := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		member := *m
+		member.Meta = copyMeta(m.Meta)
+		list = append(list, member)
+	}
+	return list
+}
+
+//SetMeta 更新当前成员的注册信息并广播,没有变化时不广播.
+func (g *Gossip) SetMeta(key, value string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if v, ok := g.self.Meta[key]; ok && v == value {
+		return
+	}
+	g.self.Meta = copyMeta(g.self.Meta)
+	g.self.Meta[key] = value
+	g.self.Incarnation++
+	g.enqueue(g.self)
+}
+
+//DeleteMeta 删除当前成员的注册信息并广播.
+func (g *Gossip) DeleteMeta(key string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if _, ok := g.self.Meta[key]; !ok {
+		return
+	}
+	g.self.Meta = copyMeta(g.self.Meta)
+	delete(g.self.Meta, key)
+	g.self.Incarnation++
+	g.enqueue(g.self)
+}
+
+//Done gossip关闭时关闭.
+func (g *Gossip) Done() <-chan struct{} {
+	return g.stop
+}
+
+//Close 停止gossip,其他成员探测失败之后认为当前成员下线.
+func (g *Gossip) Close() error {
+	select {
+	case <-g.stop:
+		return nil
+	default:
+	}
+	close(g.stop)
+	g.udp.Close()
+	g.tcp.Close()
+	g.store.close()
+	return nil
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[k] = v
+	}
+	return m
+}
+
+//randomMembers 随机获取最多n个存活的成员,不包括当前成员和exclude,调用时需要持有锁.
+func (g *Gossip) randomMembers(n int, exclude string) []string {
+	var list []string
+	for name, m := range g.members {
+		if name == g.self.Name || name == exclude || m.State != STATE_ALIVE {
+			continue
+		}
+		list = append(list, name)
+	}
+	rand.Shuffle(len(list), func(i, j int) {
+		list[i], list[j] = list[j], list[i]
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -0,0 +1,87 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+//TestStoreMerge 不同顺序合并之后状态及摘要一致,Epoch相同时Origin较大的生效.
+func TestStoreMerge(t *testing.T) {
+	a, b := newStore("a"), newStore("b")
+	a.put("k", "from a")
+	b.put("k", "from b")
+	b.put("x", "1")
+
+	listA, _ := a.list("")
+	listB, _ := b.list("")
+	a.merge(listB)
+	b.merge(listA)
+
+	for _, s := range []*store{a, b} {
+		e, ok := s.get("k")
+		if !ok || e.Value != "from b" {
+			t.Fatalf("%s: %+v", s.origin, e)
+		}
+	}
+	epochA, digestA := a.summary()
+	epochB, digestB := b.summary()
+	if epochA != epochB || digestA != digestB {
+		t.Fatalf("摘要不一致: %d/%x %d/%x", epochA, digestA, epochB, digestB)
+	}
+
+	//合并之后的修改使用已知的最大Epoch.
+	if epoch := a.put("k", "new"); epoch <= epochB {
+		t.Fatalf("epoch没有递增: %d", epoch)
+	}
+	if a.merge(listB) {
+		t.Fatal("旧的状态覆盖了新的修改")
+	}
+}
+
+//freeAddr 获取一个未被使用的本地地址.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+//TestJoin 通过种子成员加入集群之后,成员列表及集群状态在成员之间传播.
+func TestJoin(t *testing.T) {
+	cfg := Config{Addr: freeAddr(t), ProbeInterval: 50 * time.Millisecond}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	a.Put("k", "v")
+
+	cfg.Seeds, cfg.Addr = []string{cfg.Addr}, freeAddr(t)
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := b.Get("k"); !ok || e.Value != "v" {
+		t.Fatalf("加入时同步状态: %+v", e)
+	}
+
+	b.Put("k", "v2")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e, _ := a.Get("k")
+		if e.Value == "v2" && len(a.Members()) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("状态没有传播: %+v 成员: %d", e, len(a.Members()))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
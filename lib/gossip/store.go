@@ -0,0 +1,226 @@
+package gossip
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+//Entry 集群状态中的一项.
+//Epoch为逻辑时钟,每次修改取当前已知的最大值加一,合并时Epoch较大的覆盖较小的,相同时比较Origin.
+type Entry struct {
+	Key    string
+	Value  string
+	Epoch  uint64
+	Origin string //修改这一项的成员.
+}
+
+//newer e是否比other新.
+func (e Entry) newer(other Entry) bool {
+	if e.Epoch != other.Epoch {
+		return e.Epoch > other.Epoch
+	}
+	return e.Origin > other.Origin
+}
+
+//hash 用于计算集群状态的摘要.
+func (e Entry) hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.Key))
+	h.Write([]byte{0})
+	h.Write([]byte(e.Value))
+	h.Write([]byte{0})
+	h.Write([]byte(e.Origin))
+	return h.Sum64() ^ e.Epoch
+}
+
+//watcher 监听某一前缀的变化.
+type watcher struct {
+	prefix string
+	ch     chan Entry
+}
+
+//store 集群状态,所有成员最终保持一致.
+type store struct {
+	lock     *sync.Mutex
+	notify   *sync.Mutex //保证变化按顺序推送.
+	origin   string
+	entries  map[string]Entry
+	epoch    uint64 //已知的最大Epoch.
+	digest   uint64 //所有项hash的异或.
+	version  uint64 //本地应用的变化次数.
+	watchers []*watcher
+	closed   bool
+}
+
+func newStore(origin string) *store {
+	return &store{
+		lock:    &sync.Mutex{},
+		notify:  &sync.Mutex{},
+		origin:  origin,
+		entries: make(map[string]Entry),
+	}
+}
+
+//summary 当前的Epoch及摘要.
+func (s *store) summary() (uint64, uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.epoch, s.digest
+}
+
+//put 修改一项并返回修改之后的Epoch.
+func (s *store) put(key, value string) uint64 {
+	s.lock.Lock()
+	s.epoch++
+	e := Entry{Key: key, Value: value, Epoch: s.epoch, Origin: s.origin}
+	s.set(e)
+	s.publish([]Entry{e})
+	return e.Epoch
+}
+
+//merge 合并其他成员的状态,返回是否有变化.
+func (s *store) merge(list []Entry) bool {
+	s.lock.Lock()
+	var changed []Entry
+	for _, e := range list {
+		if old, ok := s.entries[e.Key]; ok && !e.newer(old) {
+			continue
+		}
+		if e.Epoch > s.epoch {
+			s.epoch = e.Epoch
+		}
+		s.set(e)
+		changed = append(changed, e)
+	}
+	s.publish(changed)
+	return len(changed) > 0
+}
+
+//set 写入一项并更新摘要,调用时需要持有锁.
+func (s *store) set(e Entry) {
+	if old, ok := s.entries[e.Key]; ok {
+		s.digest ^= old.hash()
+	}
+	s.entries[e.Key] = e
+	s.digest ^= e.hash()
+	s.version++
+}
+
+//publish 推送变化并释放lock.
+//持有notify之后再释放lock,保证推送的顺序与修改的顺序一致.
+func (s *store) publish(list []Entry) {
+	if len(list) == 0 || s.closed {
+		s.lock.Unlock()
+		return
+	}
+
+	watchers := s.watchers
+	s.notify.Lock()
+	s.lock.Unlock()
+	defer s.notify.Unlock()
+
+	for _, e := range list {
+		for _, w := range watchers {
+			if strings.HasPrefix(e.Key, w.prefix) {
+				w.ch <- e
+			}
+		}
+	}
+}
+
+//get 获取一项.
+func (s *store) get(key string) (Entry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+//list 获取前缀为prefix的所有项,同时返回本地的版本号.
+func (s *store) list(prefix string) ([]Entry, uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var list []Entry
+	for k, e := range s.entries {
+		if strings.HasPrefix(k, prefix) {
+			list = append(list, e)
+		}
+	}
+	return list, s.version
+}
+
+//watch 监听前缀为prefix的变化.
+//本地版本号大于version时先推送当前所有的项,保证不会遗漏version之后的变化.
+func (s *store) watch(prefix string, version uint64) <-chan Entry {
+	s.lock.Lock()
+	var current []Entry
+	if s.version > version {
+		for k, e := range s.entries {
+			if strings.HasPrefix(k, prefix) {
+				current = append(current, e)
+			}
+		}
+	}
+
+	w := &watcher{prefix: prefix, ch: make(chan Entry, len(current)+1024)}
+	if s.closed {
+		close(w.ch)
+		s.lock.Unlock()
+		return w.ch
+	}
+	s.watchers = append(s.watchers, w)
+
+	s.notify.Lock()
+	s.lock.Unlock()
+	defer s.notify.Unlock()
+	for _, e := range current {
+		w.ch <- e
+	}
+	return w.ch
+}
+
+//close 关闭所有监听.
+func (s *store) close() {
+	s.lock.Lock()
+	s.closed = true
+	watchers := s.watchers
+	s.watchers = nil
+	s.notify.Lock()
+	s.lock.Unlock()
+	defer s.notify.Unlock()
+
+	for _, w := range watchers {
+		close(w.ch)
+	}
+}
+
+//Put 修改集群状态中的一项,通过同步传播到其他成员.
+func (g *Gossip) Put(key, value string) uint64 {
+	epoch := g.store.put(key, value)
+	go g.spread()
+	return epoch
+}
+
+//Get 获取集群状态中的一项.
+func (g *Gossip) Get(key string) (Entry, bool) {
+	return g.store.get(key)
+}
+
+//List 获取集群状态中前缀为prefix的所有项,同时返回本地的版本号,用于Watch.
+func (g *Gossip) List(prefix string) ([]Entry, uint64) {
+	return g.store.list(prefix)
+}
+
+//Watch 监听集群状态中前缀为prefix的变化,version为List返回的版本号.
+//gossip关闭时关闭channel.
+func (g *Gossip) Watch(prefix string, version uint64) <-chan Entry {
+	return g.store.watch(prefix, version)
+}
+
+//Epoch 当前已知的最大Epoch.
+func (g *Gossip) Epoch() uint64 {
+	epoch, _ := g.store.summary()
+	return epoch
+}
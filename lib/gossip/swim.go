@@ -0,0 +1,317 @@
+package gossip
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+//msgType udp消息的类型.
+type msgType int
+
+const (
+	MSG_PING     msgType = 1 //探测.
+	MSG_ACK      msgType = 2 //探测的回复.
+	MSG_PING_REQ msgType = 3 //请求其他成员间接探测Target.
+)
+
+//MAX_PIGGYBACK 每条消息最多附带的成员变化数量.
+const MAX_PIGGYBACK = 6
+
+//RETRANSMIT_MULT 成员变化的广播次数为RETRANSMIT_MULT*log2(成员数量+1).
+const RETRANSMIT_MULT = 3
+
+//message udp消息,Epoch和Digest用于发现集群状态的差异.
+type message struct {
+	Type    msgType
+	Seq     uint32
+	From    string
+	Target  string
+	Epoch   uint64
+	Digest  uint64
+	Updates []update
+}
+
+//update 成员的变化.
+type update struct {
+	Name        string
+	Incarnation uint64
+	State       State
+	Meta        map[string]string
+}
+
+//broadcast 等待广播的成员变化.
+type broadcast struct {
+	update    update
+	transmits int
+}
+
+//enqueue 广播成员的当前状态,同一个成员只保留最新的变化,调用时需要持有锁.
+func (g *Gossip) enqueue(m *Member) {
+	u := update{Name: m.Name, Incarnation: m.Incarnation, State: m.State, Meta: m.Meta}
+	for i, b := range g.queue {
+		if b.update.Name == m.Name {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			break
+		}
+	}
+	g.queue = append(g.queue, &broadcast{update: u})
+}
+
+//piggyback 取出广播次数最少的成员变化附带在消息中,调用时需要持有锁.
+func (g *Gossip) piggyback() []update {
+	if len(g.queue) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(g.queue, func(i, j int) bool {
+		return g.queue[i].transmits < g.queue[j].transmits
+	})
+
+	limit := RETRANSMIT_MULT * int(math.Ceil(math.Log2(float64(len(g.members)+1))))
+	var list []update
+	for _, b := range g.queue {
+		if len(list) >= MAX_PIGGYBACK {
+			break
+		}
+		list = append(list, b.update)
+		b.transmits++
+	}
+
+	queue := g.queue[:0]
+	for _, b := range g.queue {
+		if b.transmits < limit {
+			queue = append(queue, b)
+		}
+	}
+	g.queue = queue
+	return list
+}
+
+//send 发送udp消息,附带成员变化及集群状态的摘要.
+func (g *Gossip) send(addr string, msg message) {
+	g.lock.Lock()
+	msg.From = g.self.Name
+	msg.Updates = g.piggyback()
+	g.lock.Unlock()
+	msg.Epoch, msg.Digest = g.store.summary()
+
+	if err := g.writeUDP(addr, msg); err != nil {
+		log.Printf("err:%+v\n", err)
+	}
+}
+
+//handle 处理收到的udp消息.
+func (g *Gossip) handle(msg message) {
+	g.lock.Lock()
+	for _, u := range msg.Updates {
+		g.apply(u)
+	}
+	g.lock.Unlock()
+
+	switch msg.Type {
+	case MSG_PING:
+		if msg.Target != g.self.Name {
+			return
+		}
+		//集群状态不一致时由被探测的一方发起同步.
+		if _, digest := g.store.summary(); digest != msg.Digest {
+			go g.syncWith(msg.From)
+		}
+		g.send(msg.From, message{Type: MSG_ACK, Seq: msg.Seq})
+	case MSG_ACK:
+		g.lock.Lock()
+		ch, ok := g.acks[msg.Seq]
+		if ok {
+			delete(g.acks, msg.Seq)
+		}
+		g.lock.Unlock()
+		if ok {
+			close(ch)
+		}
+	case MSG_PING_REQ:
+		go g.indirectProbe(msg)
+	}
+}
+
+//apply 合并成员的变化,调用时需要持有锁.
+//Incarnation较大的覆盖较小的,相同时下线覆盖疑似下线,疑似下线覆盖存活.
+func (g *Gossip) apply(u update) {
+	if u.Name == g.self.Name {
+		//其他成员认为自己疑似下线或者下线时增加Incarnation反驳.
+		if u.State != STATE_ALIVE && u.Incarnation >= g.self.Incarnation {
+			g.self.Incarnation = u.Incarnation + 1
+			g.enqueue(g.self)
+		}
+		return
+	}
+
+	m, ok := g.members[u.Name]
+	if !ok {
+		if u.State == STATE_DEAD {
+			return
+		}
+		m = &Member{Name: u.Name, Incarnation: u.Incarnation, State: u.State, Meta: u.Meta, since: time.Now()}
+		g.members[u.Name] = m
+		g.enqueue(m)
+		log.Println("gossip成员加入:", u.Name)
+		return
+	}
+
+	if u.Incarnation < m.Incarnation || u.Incarnation == m.Incarnation && u.State <= m.State {
+		return
+	}
+
+	if u.State != m.State {
+		m.since = time.Now()
+		switch u.State {
+		case STATE_ALIVE:
+			log.Println("gossip成员恢复:", u.Name)
+		case STATE_SUSPECT:
+			log.Println("gossip成员疑似下线:", u.Name)
+		case STATE_DEAD:
+			log.Println("gossip成员下线:", u.Name)
+		}
+	}
+	m.Incarnation = u.Incarnation
+	m.State = u.State
+	if u.Meta != nil {
+		m.Meta = u.Meta
+	}
+	g.enqueue(m)
+}
+
+//probeLoop 每个周期探测一个成员,同时检查疑似下线的成员是否超时.
+func (g *Gossip) probeLoop() {
+	ticker := time.NewTicker(g.cfg.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+
+		g.checkSuspects()
+		if target := g.nextProbe(); len(target) > 0 {
+			g.probe(target)
+		}
+	}
+}
+
+//nextProbe 按随机顺序依次探测所有成员,一轮结束之后重新打乱顺序.
+func (g *Gossip) nextProbe() string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for {
+		if len(g.probes) == 0 {
+			for name, m := range g.members {
+				if name != g.self.Name && m.State != STATE_DEAD {
+					g.probes = append(g.probes, name)
+				}
+			}
+			if len(g.probes) == 0 {
+				return ""
+			}
+			rand.Shuffle(len(g.probes), func(i, j int) {
+				g.probes[i], g.probes[j] = g.probes[j], g.probes[i]
+			})
+		}
+
+		name := g.probes[0]
+		g.probes = g.probes[1:]
+		if m, ok := g.members[name]; ok && m.State != STATE_DEAD {
+			return name
+		}
+	}
+}
+
+//probe 探测成员,超时之后请求其他成员间接探测,仍然没有回复则标记为疑似下线.
+func (g *Gossip) probe(target string) {
+	seq, ch := g.waitAck()
+	g.send(target, message{Type: MSG_PING, Seq: seq, Target: target})
+
+	deadline := time.NewTimer(g.cfg.ProbeInterval)
+	defer deadline.Stop()
+	select {
+	case <-ch:
+		return
+	case <-time.After(g.cfg.ProbeTimeout):
+	case <-g.stop:
+		return
+	}
+
+	g.lock.Lock()
+	helpers := g.randomMembers(g.cfg.IndirectChecks, target)
+	g.lock.Unlock()
+	for _, helper := range helpers {
+		g.send(helper, message{Type: MSG_PING_REQ, Seq: seq, Target: target})
+	}
+
+	select {
+	case <-ch:
+		return
+	case <-deadline.C:
+	case <-g.stop:
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.cancelAck(seq)
+	if m, ok := g.members[target]; ok && m.State == STATE_ALIVE {
+		g.apply(update{Name: m.Name, Incarnation: m.Incarnation, State: STATE_SUSPECT})
+	}
+}
+
+//indirectProbe 代替其他成员探测Target,收到回复之后转发给请求的成员.
+func (g *Gossip) indirectProbe(req message) {
+	seq, ch := g.waitAck()
+	g.send(req.Target, message{Type: MSG_PING, Seq: seq, Target: req.Target})
+
+	select {
+	case <-ch:
+		g.send(req.From, message{Type: MSG_ACK, Seq: req.Seq})
+	case <-time.After(g.cfg.ProbeInterval):
+		g.lock.Lock()
+		g.cancelAck(seq)
+		g.lock.Unlock()
+	case <-g.stop:
+	}
+}
+
+//waitAck 分配探测的序号并等待回复.
+func (g *Gossip) waitAck() (uint32, chan struct{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.seq++
+	ch := make(chan struct{})
+	g.acks[g.seq] = ch
+	return g.seq, ch
+}
+
+//cancelAck 放弃等待回复,调用时需要持有锁.
+func (g *Gossip) cancelAck(seq uint32) {
+	delete(g.acks, seq)
+}
+
+//checkSuspects 疑似下线超时的成员标记为下线,下线超过DEAD_RETAIN的成员从列表中删除.
+func (g *Gossip) checkSuspects() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := time.Now()
+	for name, m := range g.members {
+		switch {
+		case m.State == STATE_SUSPECT && now.Sub(m.since) > g.cfg.SuspectTimeout:
+			g.apply(update{Name: m.Name, Incarnation: m.Incarnation, State: STATE_DEAD})
+		case m.State == STATE_DEAD && now.Sub(m.since) > DEAD_RETAIN:
+			delete(g.members, name)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package gossip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+//MAX_UDP_SIZE udp消息的最大长度.
+const MAX_UDP_SIZE = 65507
+
+//MAX_SYNC_SIZE 同步全部状态时单个消息的最大长度.
+const MAX_SYNC_SIZE = 64 << 20
+
+//SYNC_TIMEOUT 同步全部状态的超时时间.
+const SYNC_TIMEOUT = 10 * time.Second
+
+//SPREAD_FANOUT 本地修改集群状态之后立即同步的成员数量.
+const SPREAD_FANOUT = 3
+
+var (
+	ErrSignature = errors.New("gossip消息签名错误")
+	ErrTooLarge  = errors.New("gossip消息过大")
+)
+
+//state 同步全部状态时交换的内容.
+type state struct {
+	From    string
+	Members []update
+	Entries []Entry
+}
+
+//seal 序列化消息,配置了密钥时在前面附加签名.
+func (g *Gossip) seal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.cfg.Secret) == 0 {
+		return b, nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(g.cfg.Secret))
+	mac.Write(b)
+	return append(mac.Sum(nil), b...), nil
+}
+
+//open 校验签名并反序列化消息.
+func (g *Gossip) open(b []byte, v interface{}) error {
+	if len(g.cfg.Secret) > 0 {
+		if len(b) < sha256.Size {
+			return ErrSignature
+		}
+		mac := hmac.New(sha256.New, []byte(g.cfg.Secret))
+		mac.Write(b[sha256.Size:])
+		if !hmac.Equal(mac.Sum(nil), b[:sha256.Size]) {
+			return ErrSignature
+		}
+		b = b[sha256.Size:]
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (g *Gossip) writeUDP(addr string, msg message) error {
+	b, err := g.seal(msg)
+	if err != nil {
+		return err
+	}
+	if len(b) > MAX_UDP_SIZE {
+		return ErrTooLarge
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	_, err = g.udp.WriteToUDP(b, raddr)
+	return err
+}
+
+func (g *Gossip) readUDP() {
+	buf := make([]byte, MAX_UDP_SIZE)
+	for {
+		n, addr, err := g.udp.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.stop:
+				return
+			default:
+			}
+			log.Printf("err:%+v\n", err)
+			continue
+		}
+
+		msg := message{}
+		if err := g.open(buf[:n], &msg); err != nil {
+			log.Println("无效的gossip消息:", addr, err)
+			continue
+		}
+		g.handle(msg)
+	}
+}
+
+//writeFrame 写入带长度的消息.
+func writeFrame(conn net.Conn, b []byte) error {
+	head := make([]byte, 4)
+	binary.BigEndian.PutUint32(head, uint32(len(b)))
+	if _, err := conn.Write(head); err != nil {
+		return err
+	}
+	_, err := conn.Write(b)
+	return err
+}
+
+//readFrame 读取带长度的消息.
+func readFrame(conn net.Conn) ([]byte, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head)
+	if size > MAX_SYNC_SIZE {
+		return nil, ErrTooLarge
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//localState 当前成员已知的全部状态.
+func (g *Gossip) localState() state {
+	g.lock.Lock()
+	st := state{From: g.self.Name}
+	for _, m := range g.members {
+		st.Members = append(st.Members, update{Name: m.Name, Incarnation: m.Incarnation, State: m.State, Meta: m.Meta})
+	}
+	g.lock.Unlock()
+
+	st.Entries, _ = g.store.list("")
+	return st
+}
+
+//mergeState 合并其他成员的全部状态.
+func (g *Gossip) mergeState(st state) {
+	g.lock.Lock()
+	for _, u := range st.Members {
+		g.apply(u)
+	}
+	g.lock.Unlock()
+
+	g.store.merge(st.Entries)
+}
+
+//pushPull 与成员交换全部状态.
+func (g *Gossip) pushPull(addr string) error {
+	conn, err := net.DialTimeout("tcp4", addr, SYNC_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(SYNC_TIMEOUT))
+
+	b, err := g.seal(g.localState())
+	if err != nil {
+		return err
+	}
+	if err := writeFrame(conn, b); err != nil {
+		return err
+	}
+
+	b, err = readFrame(conn)
+	if err != nil {
+		return err
+	}
+	st := state{}
+	if err := g.open(b, &st); err != nil {
+		return err
+	}
+	g.mergeState(st)
+	return nil
+}
+
+func (g *Gossip) acceptTCP() {
+	for {
+		conn, err := g.tcp.Accept()
+		if err != nil {
+			select {
+			case <-g.stop:
+				return
+			default:
+			}
+			log.Printf("err:%+v\n", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go g.handleSync(conn)
+	}
+}
+
+//handleSync 其他成员发起的同步,先读取对方的状态再返回本地的状态.
+func (g *Gossip) handleSync(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(SYNC_TIMEOUT))
+
+	b, err := readFrame(conn)
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+	st := state{}
+	if err := g.open(b, &st); err != nil {
+		log.Println("无效的gossip同步:", conn.RemoteAddr(), err)
+		return
+	}
+
+	b, err = g.seal(g.localState())
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+	if err := writeFrame(conn, b); err != nil {
+		log.Printf("err:%+v\n", err)
+		return
+	}
+	g.mergeState(st)
+}
+
+//syncWith 与成员同步全部状态,同一个成员同时只进行一次同步.
+func (g *Gossip) syncWith(addr string) {
+	g.lock.Lock()
+	if g.syncing[addr] {
+		g.lock.Unlock()
+		return
+	}
+	g.syncing[addr] = true
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.syncing, addr)
+		g.lock.Unlock()
+	}()
+
+	if err := g.pushPull(addr); err != nil {
+		log.Println("gossip同步失败:", addr, err)
+	}
+}
+
+//spread 本地修改之后立即与几个随机成员同步,其余成员在探测时发现摘要不一致之后同步.
+func (g *Gossip) spread() {
+	g.lock.Lock()
+	list := g.randomMembers(SPREAD_FANOUT, "")
+	g.lock.Unlock()
+
+	for _, addr := range list {
+		g.syncWith(addr)
+	}
+}
+
+//pushPullLoop 定时与随机成员同步全部状态,没有存活的成员时重新连接种子成员.
+func (g *Gossip) pushPullLoop() {
+	ticker := time.NewTicker(g.cfg.PushPullInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+
+		g.lock.Lock()
+		list := g.randomMembers(1, "")
+		g.lock.Unlock()
+
+		if len(list) > 0 {
+			g.syncWith(list[0])
+			continue
+		}
+		for _, seed := range g.cfg.Seeds {
+			if seed != g.cfg.Addr {
+				g.syncWith(seed)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package packet
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//PROTOCOL_VERSION 当前协议版本号.
+const PROTOCOL_VERSION = 1
+
+//MIN_PROTOCOL_VERSION 兼容的最低协议版本号.
+const MIN_PROTOCOL_VERSION = 1
+
+//Capability 连接能力,握手时协商,双方都支持的能力才会启用.
+type Capability uint32
+
+const (
+	CAP_MULTIPLEX Capability = 1 << iota //请求ID多路复用,同一个连接上的请求并发处理,返回顺序与请求顺序无关.
+	CAP_EPOCH                            //请求内容前附加发送方路由表的epoch,路由表过期时返回MOVED.
+	CAP_CHECKSUM                         //握手之后的数据包头部附加CRC32C校验值.
+)
+
+//CAPABILITIES 当前版本支持的能力.
+const CAPABILITIES = CAP_MULTIPLEX | CAP_EPOCH | CAP_CHECKSUM
+
+var ErrVersion = errors.New("协议版本不支持")
+var ErrAuth = errors.New("连接授权失败")
+var ErrNoSecret = errors.New("没有配置auth_secret, 请在配置文件中设置与集群一致的密钥")
+
+//Handshake 握手协商的结果.
+type Handshake struct {
+	Version uint32
+	Caps    Capability
+}
+
+//Has 是否启用了指定的能力.
+func (h Handshake) Has(c Capability) bool {
+	return h.Caps&c == c
+}
+
+//Auth 建立连接之后发送握手请求,请求参数为协议版本号、密钥、能力,返回协商的结果.
+//握手请求及回复使用不带校验值的数据包,保证不同版本之间可以完成握手,之后的数据包使用协商的格式.
+func Auth(conn io.ReadWriter, secret string, caps Capability) (h Handshake, err error) {
+	body := EncodeArgs(strconv.Itoa(PROTOCOL_VERSION), secret, strconv.FormatUint(uint64(caps), 10))
+	if _, err := conn.Write(NewRequest(body, CONN_AUTH)); err != nil {
+		return h, err
+	}
+
+	pkt, err := ParseResponse(conn)
+	if err != nil {
+		return h, err
+	}
+	if pkt.Err != errcode.NO_ERROR {
+		return h, errors.New(pkt.Msg)
+	}
+
+	args, err := DecodeArgs([]byte(pkt.Msg))
+	if err != nil || len(args) < 2 {
+		return h, ErrMalformed
+	}
+
+	h, err = parseHandshake(args[0], args[1])
+	if err != nil {
+		return h, err
+	}
+	if h.Version < MIN_PROTOCOL_VERSION {
+		return h, fmt.Errorf("%w: %d", ErrVersion, h.Version)
+	}
+	return h, nil
+}
+
+//Accept 校验握手请求,返回协商的结果及需要回复的内容.
+//客户端的版本号高于当前版本时使用当前版本,低于兼容的最低版本时拒绝连接.
+func Accept(body []byte, secret string) (h Handshake, msg string, err error) {
+	args, err := DecodeArgs(body)
+	if err != nil || len(args) < 3 {
+		return h, msg, ErrMalformed
+	}
+
+	client, err := parseHandshake(args[0], args[2])
+	if err != nil {
+		return h, msg, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(args[1]), []byte(secret)) != 1 {
+		return h, msg, ErrAuth
+	}
+
+	if client.Version < MIN_PROTOCOL_VERSION {
+		return h, msg, fmt.Errorf("%w: %d", ErrVersion, client.Version)
+	}
+
+	h.Version = client.Version
+	if h.Version > PROTOCOL_VERSION {
+		h.Version = PROTOCOL_VERSION
+	}
+	h.Caps = client.Caps & CAPABILITIES
+
+	msg = string(EncodeArgs(strconv.FormatUint(uint64(h.Version), 10), strconv.FormatUint(uint64(h.Caps), 10)))
+	return h, msg, nil
+}
+
+func parseHandshake(version, caps string) (h Handshake, err error) {
+	v, err := strconv.ParseUint(version, 10, 32)
+	if err != nil {
+		return h, ErrMalformed
+	}
+	c, err := strconv.ParseUint(caps, 10, 32)
+	if err != nil {
+		return h, ErrMalformed
+	}
+
+	h.Version = uint32(v)
+	h.Caps = Capability(c)
+	return h, nil
+}
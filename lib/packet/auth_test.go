@@ -0,0 +1,32 @@
+package packet
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+//TestAcceptVersion 低于兼容版本的握手返回ErrVersion,高于当前版本时使用当前版本.
+func TestAcceptVersion(t *testing.T) {
+	body := EncodeArgs(strconv.Itoa(MIN_PROTOCOL_VERSION-1), "secret", "0")
+	if _, _, err := Accept(body, "secret"); !errors.Is(err, ErrVersion) {
+		t.Fatalf("低版本: %v", err)
+	}
+
+	body = EncodeArgs(strconv.Itoa(PROTOCOL_VERSION+1), "secret", strconv.Itoa(int(CAP_CHECKSUM)))
+	h, _, err := Accept(body, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != PROTOCOL_VERSION || !h.Has(CAP_CHECKSUM) {
+		t.Fatalf("协商结果: %+v", h)
+	}
+}
+
+//TestAcceptSecret 密钥不一致时拒绝连接.
+func TestAcceptSecret(t *testing.T) {
+	body := EncodeArgs(strconv.Itoa(PROTOCOL_VERSION), "wrong", "0")
+	if _, _, err := Accept(body, "secret"); err != ErrAuth {
+		t.Fatalf("密钥错误: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//请求参数及返回的列表使用相同的二进制编码:
+//0-4 为元素个数,之后每个元素为4个字节的长度加内容,长度为NIL_LEN时表示nil.
+
+//NIL_LEN nil元素的长度.
+const NIL_LEN = 0xFFFFFFFF
+
+var ErrMalformed = errors.New("数据格式错误")
+
+//EncodeArgs 编码请求参数.
+func EncodeArgs(args ...string) []byte {
+	size := 4
+	for _, arg := range args {
+		size += 4 + len(arg)
+	}
+
+	buf := make([]byte, 4, size)
+	binary.BigEndian.PutUint32(buf, uint32(len(args)))
+	for _, arg := range args {
+		buf = appendItem(buf, uint32(len(arg)), arg)
+	}
+	return buf
+}
+
+//EncodeArray 编码可以包含nil的列表.
+func EncodeArray(list []*string) []byte {
+	size := 4
+	for _, item := range list {
+		size += 4
+		if item != nil {
+			size += len(*item)
+		}
+	}
+
+	buf := make([]byte, 4, size)
+	binary.BigEndian.PutUint32(buf, uint32(len(list)))
+	for _, item := range list {
+		if item == nil {
+			buf = appendItem(buf, NIL_LEN, "")
+			continue
+		}
+		buf = appendItem(buf, uint32(len(*item)), *item)
+	}
+	return buf
+}
+
+func appendItem(buf []byte, n uint32, item string) []byte {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], n)
+	buf = append(buf, size[:]...)
+	return append(buf, item...)
+}
+
+//DecodeArgs 解析请求参数,nil元素解析为空字符串.
+func DecodeArgs(buf []byte) ([]string, error) {
+	list, err := DecodeArray(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(list))
+	for i, item := range list {
+		if item != nil {
+			args[i] = *item
+		}
+	}
+	return args, nil
+}
+
+//DecodeArray 解析可以包含nil的列表.
+func DecodeArray(buf []byte) ([]*string, error) {
+	if len(buf) < 4 {
+		return nil, ErrMalformed
+	}
+	n := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+
+	//每个元素至少占用4个字节,避免按错误的元素个数分配内存.
+	if uint64(n)*4 > uint64(len(buf)) {
+		return nil, ErrMalformed
+	}
+
+	list := make([]*string, n)
+	for i := range list {
+		if len(buf) < 4 {
+			return nil, ErrMalformed
+		}
+		size := binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+		if size == NIL_LEN {
+			continue
+		}
+
+		if uint64(size) > uint64(len(buf)) {
+			return nil, ErrMalformed
+		}
+		item := string(buf[:size])
+		list[i] = &item
+		buf = buf[size:]
+	}
+
+	if len(buf) > 0 {
+		return nil, ErrMalformed
+	}
+	return list, nil
+}
+
+//WalkArgs 依次访问请求参数中的元素,不复制内容,fn返回false时停止.
+//只检查已经访问的元素的长度,nil元素的内容为nil.
+func WalkArgs(buf []byte, fn func(i int, arg []byte) bool) error {
+	if len(buf) < 4 {
+		return ErrMalformed
+	}
+	n := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+
+	for i := 0; i < int(n); i++ {
+		if len(buf) < 4 {
+			return ErrMalformed
+		}
+		size := binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+		if size == NIL_LEN {
+			if !fn(i, nil) {
+				return nil
+			}
+			continue
+		}
+
+		if uint64(size) > uint64(len(buf)) {
+			return ErrMalformed
+		}
+		if !fn(i, buf[:size]) {
+			return nil
+		}
+		buf = buf[size:]
+	}
+	return nil
+}
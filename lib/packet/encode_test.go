@@ -0,0 +1,37 @@
+package packet
+
+import (
+	"reflect"
+	"testing"
+)
+
+//TestEncodeArgs 包含任意字节的参数编码之后原样解析,nil元素保留.
+func TestEncodeArgs(t *testing.T) {
+	args := []string{"", "a b", "\x00\xff\r\n", "{\"json\":1}"}
+	list, err := DecodeArgs(EncodeArgs(args...))
+	if err != nil || !reflect.DeepEqual(list, args) {
+		t.Fatalf("解析: %q %v", list, err)
+	}
+
+	value := "v"
+	items, err := DecodeArray(EncodeArray([]*string{&value, nil}))
+	if err != nil || len(items) != 2 || *items[0] != "v" || items[1] != nil {
+		t.Fatalf("解析nil: %v %v", items, err)
+	}
+}
+
+//TestDecodeMalformed 长度与内容不一致的数据返回ErrMalformed.
+func TestDecodeMalformed(t *testing.T) {
+	buf := EncodeArgs("key", "value")
+	cases := [][]byte{
+		nil,
+		buf[:len(buf)-1],
+		append(append([]byte{}, buf...), 0),
+		{0xff, 0xff, 0xff, 0xff},
+	}
+	for _, b := range cases {
+		if _, err := DecodeArgs(b); err != ErrMalformed {
+			t.Errorf("%x: %v", b, err)
+		}
+	}
+}
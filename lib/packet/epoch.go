@@ -0,0 +1,38 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//EPOCH_LEN 协商了CAP_EPOCH时请求内容前附加的epoch长度.
+const EPOCH_LEN = 8
+
+//WithEpoch 在请求内容前附加发送方路由表的epoch,0表示不检查路由表.
+func WithEpoch(epoch uint64, content []byte) []byte {
+	buf := make([]byte, EPOCH_LEN+len(content))
+	binary.BigEndian.PutUint64(buf, epoch)
+	copy(buf[EPOCH_LEN:], content)
+	return buf
+}
+
+//SplitEpoch 拆分请求内容前附加的epoch.
+func SplitEpoch(body []byte) (epoch uint64, content []byte, err error) {
+	if len(body) < EPOCH_LEN {
+		return epoch, content, ErrMalformed
+	}
+	return binary.BigEndian.Uint64(body), body[EPOCH_LEN:], nil
+}
+
+//Moved MOVED错误的消息,内容为插槽id、插槽当前所在的节点及插槽的epoch.
+func Moved(slot int, ip string, epoch uint64) string {
+	return fmt.Sprintf("MOVED %d %s %d", slot, ip, epoch)
+}
+
+//ParseMoved 解析MOVED错误的消息.
+func ParseMoved(msg string) (slot int, ip string, epoch uint64, err error) {
+	if _, err := fmt.Sscanf(msg, "MOVED %d %s %d", &slot, &ip, &epoch); err != nil {
+		return slot, ip, epoch, ErrMalformed
+	}
+	return slot, ip, epoch, nil
+}
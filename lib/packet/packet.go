@@ -2,16 +2,38 @@ package packet
 
 import (
 	"encoding/binary"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
-	"net"
+	"sync/atomic"
 
 	"github.com/houzhongjian/bigcache/lib/errcode"
 )
 
 const PROROCOL_LEN = 4
+const REQUEST_ID_LEN = 4
 const HEADER_LEN = 4
+const CHECKSUM_LEN = 4
+const ERRCODE_LEN = 4
+
+//FRAME_HEADER_LEN 数据包头部长度.
+const FRAME_HEADER_LEN = PROROCOL_LEN + REQUEST_ID_LEN + HEADER_LEN
+
+//CHECKSUM_HEADER_LEN 协商了CAP_CHECKSUM时数据包头部长度.
+const CHECKSUM_HEADER_LEN = FRAME_HEADER_LEN + CHECKSUM_LEN
+
+//DEFAULT_MAX_FRAME_SIZE 默认的数据包内容最大长度.
+const DEFAULT_MAX_FRAME_SIZE = 64 << 20
+
+var maxFrameSize uint32 = DEFAULT_MAX_FRAME_SIZE
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+//解析数据包时返回的协议错误,之后的数据已经无法解析,需要关闭连接.
+var ErrFrameTooLarge = errors.New("数据包超过最大长度")
+var ErrChecksum = errors.New("数据包校验失败")
 
 //BigcacheProtocol 传输协议号.
 type BigcacheProtocol int
@@ -20,124 +42,229 @@ const (
 	CONN_AUTH            BigcacheProtocol = 1000 //连接授权.
 	READ                 BigcacheProtocol = 1001 //获取一条记录.
 	WRITE                BigcacheProtocol = 1002 //写入一条记录.
-	DELETE               BigcacheProtocol = 1003 //删除一条记录.
+	DELETE               BigcacheProtocol = 1003 //删除记录,返回删除的条数.
 	MSG                  BigcacheProtocol = 1004 //发生一条状态消息.
 	ADD_NODE             BigcacheProtocol = 1005 //新增加节点.
 	REMOVE_NODE          BigcacheProtocol = 1006 //删除节点.
 	GET_CACHE_SERVER_ALL BigcacheProtocol = 1007 //获取所有的cache server 节点.
+	MIGRATE              BigcacheProtocol = 1008 //将插槽数据迁移到目标节点.
+	MIGRATE_WRITE        BigcacheProtocol = 1009 //迁移写入一条记录,目标节点已存在时不覆盖.
+	SLOT_KEYS            BigcacheProtocol = 1010 //分页获取插槽中的key.
+	SLOT_COUNT           BigcacheProtocol = 1011 //获取插槽中的数据条数.
+	PING                 BigcacheProtocol = 1012 //心跳检测.
+	EXPIRE               BigcacheProtocol = 1013 //设置过期时间,单位毫秒.
+	EXPIRE_AT            BigcacheProtocol = 1014 //设置过期时间点,unix时间戳,单位毫秒.
+	TTL                  BigcacheProtocol = 1015 //获取剩余过期时间,单位毫秒.
+	PERSIST              BigcacheProtocol = 1016 //移除过期时间.
+	DUMP                 BigcacheProtocol = 1017 //序列化一条记录,用于迁移.
+	INCR                 BigcacheProtocol = 1018 //原子增加整数.
+	INCR_FLOAT           BigcacheProtocol = 1019 //原子增加浮点数.
+	HSET                 BigcacheProtocol = 1020 //写入hash的field.
+	HGET                 BigcacheProtocol = 1021 //读取hash的field.
+	HMGET                BigcacheProtocol = 1022 //读取hash的多个field.
+	HGETALL              BigcacheProtocol = 1023 //读取hash的所有field.
+	HDEL                 BigcacheProtocol = 1024 //删除hash的field.
+	HINCRBY              BigcacheProtocol = 1025 //原子增加hash的field.
+	HLEN                 BigcacheProtocol = 1026 //获取hash的field个数.
+	LPUSH                BigcacheProtocol = 1027 //从list头部插入元素.
+	RPUSH                BigcacheProtocol = 1028 //从list尾部插入元素.
+	LPOP                 BigcacheProtocol = 1029 //从list头部弹出元素.
+	RPOP                 BigcacheProtocol = 1030 //从list尾部弹出元素.
+	LRANGE               BigcacheProtocol = 1031 //获取list指定区间的元素.
+	LLEN                 BigcacheProtocol = 1032 //获取list的元素个数.
+	LTRIM                BigcacheProtocol = 1033 //只保留list指定区间的元素.
+	SADD                 BigcacheProtocol = 1034 //添加set成员.
+	SREM                 BigcacheProtocol = 1035 //删除set成员.
+	SMEMBERS             BigcacheProtocol = 1036 //获取set的所有成员.
+	SISMEMBER            BigcacheProtocol = 1037 //判断是否为set成员.
+	SCARD                BigcacheProtocol = 1038 //获取set的成员个数.
+	SINTER               BigcacheProtocol = 1039 //获取多个set的交集.
+	SUNION               BigcacheProtocol = 1040 //获取多个set的并集.
+	ZADD                 BigcacheProtocol = 1041 //添加zset成员.
+	ZRANGE               BigcacheProtocol = 1042 //按排名获取zset成员.
+	ZRANGE_BY_SCORE      BigcacheProtocol = 1043 //按分数获取zset成员.
+	ZREM                 BigcacheProtocol = 1044 //删除zset成员.
+	ZSCORE               BigcacheProtocol = 1045 //读取zset成员的分数.
+	ZINCRBY              BigcacheProtocol = 1046 //原子增加zset成员的分数.
+	ZCARD                BigcacheProtocol = 1047 //获取zset的成员个数.
+	MREAD                BigcacheProtocol = 1048 //读取多条记录.
+	MWRITE               BigcacheProtocol = 1049 //写入多条记录.
+	REPL_SYNC            BigcacheProtocol = 1050 //从节点请求同步,之后连接用于推送复制日志.
+	REPL_SNAPSHOT        BigcacheProtocol = 1051 //全量同步的数据.
+	REPL_SNAPSHOT_END    BigcacheProtocol = 1052 //全量同步结束,内容为快照对应的复制ID和序号.
+	REPL_ENTRY           BigcacheProtocol = 1053 //一条复制日志.
+	REPL_PING            BigcacheProtocol = 1054 //主节点没有新的复制日志时发送的心跳,内容为最新的序号.
+	REPL_ACK             BigcacheProtocol = 1055 //从节点确认已经写入的序号.
+	REPLICA_OF           BigcacheProtocol = 1056 //设置主节点,为空时成为主节点.
+	REPL_INFO            BigcacheProtocol = 1057 //获取复制状态.
+	MIGRATE_KEY          BigcacheProtocol = 1058 //将一个key迁移到目标节点,写入目标节点之后删除本地数据.
 )
 
 type Request struct {
 	Protocol BigcacheProtocol
+	ID       uint32 //请求ID,返回数据包中原样带回,用于多路复用,0表示不使用.
 	Size     int64
 	Body     []byte
 }
 
 type Response struct {
 	Protocol BigcacheProtocol
+	ID       uint32
 	Size     int64
 	Body     []byte
 	Msg      string
 	Err      errcode.BigcacheError
 }
 
+//NewRequest 创建不带校验值的请求数据包,用于握手.
 func NewRequest(content []byte, num BigcacheProtocol) []byte {
-	buffer := make([]byte, HEADER_LEN+len(content)+PROROCOL_LEN)
+	return NewRequestWithID(0, content, num)
+}
+
+//NewRequestWithID 创建带请求ID、不带校验值的请求数据包.
+func NewRequestWithID(id uint32, content []byte, num BigcacheProtocol) []byte {
+	return Handshake{}.NewRequest(id, content, num)
+}
+
+//NewRequest 按握手协商的格式创建请求数据包.
+func (h Handshake) NewRequest(id uint32, content []byte, num BigcacheProtocol) []byte {
+	size := FRAME_HEADER_LEN
+	if h.Has(CAP_CHECKSUM) {
+		size = CHECKSUM_HEADER_LEN
+	}
+
+	buffer := make([]byte, size+len(content))
 	//0-4 为协议号.
-	//4-8 为内容大小.
-	//>8 为内容.
+	//4-8 为请求ID.
+	//8-12 为内容大小.
+	//协商了CAP_CHECKSUM时12-16 为前12个字节及内容的CRC32C校验值.
+	//之后为内容.
 	binary.BigEndian.PutUint32(buffer[0:4], uint32(num))
-	binary.BigEndian.PutUint32(buffer[4:8], uint32(len(content)))
-	copy(buffer[8:], content)
+	binary.BigEndian.PutUint32(buffer[4:8], id)
+	binary.BigEndian.PutUint32(buffer[8:12], uint32(len(content)))
+	copy(buffer[size:], content)
+	if h.Has(CAP_CHECKSUM) {
+		binary.BigEndian.PutUint32(buffer[12:16], checksum(buffer[0:12], buffer[16:]))
+	}
 	return buffer
 }
-func NewResponse(msg string, errcode errcode.BigcacheError) []byte {
-	resp := Response{
-		Err: errcode,
-		Msg: msg,
-	}
-	buf, err := json.Marshal(resp)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		return nil
+
+//checksum 计算数据包的CRC32C校验值.
+func checksum(header, body []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, header)
+	return crc32.Update(crc, castagnoli, body)
+}
+
+//SetMaxFrameSize 设置数据包内容的最大长度,为0时使用默认值.
+func SetMaxFrameSize(size uint32) {
+	if size == 0 {
+		size = DEFAULT_MAX_FRAME_SIZE
 	}
-	// log.Println(string(buf))
-	buffer := make([]byte, HEADER_LEN+len(buf)+PROROCOL_LEN)
-	//0-4 为协议号.
-	//4-8 为内容大小.
-	//>8 为内容.
-	binary.BigEndian.PutUint32(buffer[0:4], uint32(MSG))
-	binary.BigEndian.PutUint32(buffer[4:8], uint32(len(buf)))
-	copy(buffer[8:], buf)
-	return buffer
+	atomic.StoreUint32(&maxFrameSize, size)
 }
 
-//ParseRequest 解析网络请求数据包.
-func ParseRequest(conn net.Conn) (pkt Request, err error) {
-	//获取协议号.
-	var num = make([]byte, PROROCOL_LEN)
-	_, err = io.ReadFull(conn, num)
-	if err != nil {
-		return pkt, err
+//MaxFrameSize 获取数据包内容的最大长度.
+func MaxFrameSize() uint32 {
+	return atomic.LoadUint32(&maxFrameSize)
+}
+
+//CheckFrameSize 检查内容长度是否超过最大长度,超过时对方会拒绝数据包并关闭连接.
+func CheckFrameSize(n int) error {
+	if uint64(n) > uint64(MaxFrameSize()) {
+		return fmt.Errorf("%w: %d", ErrFrameTooLarge, n)
 	}
-	pkt.Protocol = BigcacheProtocol(int(binary.BigEndian.Uint32(num)))
+	return nil
+}
 
-	//获取内容长度.
-	var header = make([]byte, HEADER_LEN)
-	_, err = io.ReadFull(conn, header)
+func NewResponse(msg string, errcode errcode.BigcacheError) []byte {
+	return NewResponseWithID(0, msg, errcode)
+}
+
+//NewResponseWithID 创建不带校验值的返回数据包,id为对应请求的请求ID.
+func NewResponseWithID(id uint32, msg string, errcode errcode.BigcacheError) []byte {
+	return Handshake{}.NewResponse(id, msg, errcode)
+}
+
+//NewResponse 按握手协商的格式创建返回数据包,id为对应请求的请求ID.
+//内容为4个字节的错误码加消息,消息为原始的二进制数据.
+func (h Handshake) NewResponse(id uint32, msg string, errcode errcode.BigcacheError) []byte {
+	buf := make([]byte, ERRCODE_LEN+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(errcode))
+	copy(buf[ERRCODE_LEN:], msg)
+	return h.NewRequest(id, buf, MSG)
+}
+
+//readFrame 读取数据包,返回协议号、请求ID和内容.
+//内容超过最大长度时不再读取内容,返回ErrFrameTooLarge,校验失败时返回ErrChecksum.
+func (h Handshake) readFrame(r io.Reader) (num BigcacheProtocol, id uint32, body []byte, err error) {
+	//获取协议号、请求ID、内容长度,协商了CAP_CHECKSUM时还有校验值.
+	headerLen := FRAME_HEADER_LEN
+	if h.Has(CAP_CHECKSUM) {
+		headerLen = CHECKSUM_HEADER_LEN
+	}
+	var header = make([]byte, headerLen)
+	_, err = io.ReadFull(r, header)
 	if err != nil {
-		return pkt, err
+		return num, id, body, err
+	}
+	num = BigcacheProtocol(int(binary.BigEndian.Uint32(header[0:4])))
+	id = binary.BigEndian.Uint32(header[4:8])
+	size := binary.BigEndian.Uint32(header[8:12])
+	if size > MaxFrameSize() {
+		return num, id, body, fmt.Errorf("%w: %d", ErrFrameTooLarge, size)
 	}
-	pkt.Size = int64(binary.BigEndian.Uint32(header))
 
 	//获取内容
-	var buf = make([]byte, pkt.Size)
-	_, err = io.ReadFull(conn, buf)
+	body = make([]byte, size)
+	_, err = io.ReadFull(r, body)
 	if err != nil {
-		return pkt, err
+		return num, id, body, err
 	}
-	pkt.Body = buf
 
-	return pkt, nil
+	if h.Has(CAP_CHECKSUM) && checksum(header[0:12], body) != binary.BigEndian.Uint32(header[12:16]) {
+		return num, id, nil, ErrChecksum
+	}
+
+	return num, id, body, nil
 }
 
-//ParseRequest 解析网络返回数据包.
-func ParseResponse(conn net.Conn) (pkt Response, err error) {
-	//获取协议号.
-	var num = make([]byte, PROROCOL_LEN)
-	_, err = io.ReadFull(conn, num)
-	if err != nil {
-		log.Printf("err:%+v\n", err)
-		return pkt, err
-	}
-	pkt.Protocol = BigcacheProtocol(int(binary.BigEndian.Uint32(num)))
+//ParseRequest 解析不带校验值的请求数据包,用于握手.
+func ParseRequest(r io.Reader) (pkt Request, err error) {
+	return Handshake{}.ParseRequest(r)
+}
 
-	//获取内容长度.
-	var header = make([]byte, HEADER_LEN)
-	_, err = io.ReadFull(conn, header)
+//ParseRequest 按握手协商的格式解析请求数据包.
+func (h Handshake) ParseRequest(r io.Reader) (pkt Request, err error) {
+	pkt.Protocol, pkt.ID, pkt.Body, err = h.readFrame(r)
 	if err != nil {
-		log.Printf("err:%+v\n", err)
 		return pkt, err
 	}
-	pkt.Size = int64(binary.BigEndian.Uint32(header))
+	pkt.Size = int64(len(pkt.Body))
 
-	//获取内容
-	var buf = make([]byte, pkt.Size)
-	_, err = io.ReadFull(conn, buf)
+	return pkt, nil
+}
+
+//ParseResponse 解析不带校验值的返回数据包,用于握手.
+func ParseResponse(r io.Reader) (pkt Response, err error) {
+	return Handshake{}.ParseResponse(r)
+}
+
+//ParseResponse 按握手协商的格式解析返回数据包.
+func (h Handshake) ParseResponse(r io.Reader) (pkt Response, err error) {
+	pkt.Protocol, pkt.ID, pkt.Body, err = h.readFrame(r)
 	if err != nil {
 		log.Printf("err:%+v\n", err)
 		return pkt, err
 	}
-	pkt.Body = buf
+	pkt.Size = int64(len(pkt.Body))
 	// log.Println(string(pkt.Body))
 
-	content := Response{}
-	if err := json.Unmarshal(pkt.Body, &content); err != nil {
-		log.Printf("err:%+v\n", err)
-		return pkt, err
+	if len(pkt.Body) < ERRCODE_LEN {
+		log.Printf("err:%+v\n", ErrMalformed)
+		return pkt, ErrMalformed
 	}
-	pkt.Err = content.Err
-	pkt.Msg = content.Msg
+	pkt.Err = errcode.BigcacheError(binary.BigEndian.Uint32(pkt.Body))
+	pkt.Msg = string(pkt.Body[ERRCODE_LEN:])
 
 	return pkt, nil
 }
@@ -0,0 +1,59 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/lib/errcode"
+)
+
+//TestRoundTrip 请求及返回数据包编码之后可以解析出相同的协议号、请求ID和内容.
+func TestRoundTrip(t *testing.T) {
+	for _, h := range []Handshake{{}, {Version: PROTOCOL_VERSION, Caps: CAP_CHECKSUM}} {
+		body := []byte("key\x00value")
+		req, err := h.ParseRequest(bytes.NewReader(h.NewRequest(42, body, WRITE)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Protocol != WRITE || req.ID != 42 || !bytes.Equal(req.Body, body) {
+			t.Fatalf("请求: %+v", req)
+		}
+
+		resp, err := h.ParseResponse(bytes.NewReader(h.NewResponse(7, "ok", errcode.NO_ERROR)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ID != 7 || resp.Msg != "ok" || resp.Err != errcode.NO_ERROR {
+			t.Fatalf("返回: %+v", resp)
+		}
+	}
+}
+
+//TestChecksum 协商了CAP_CHECKSUM时,内容被修改的数据包返回ErrChecksum.
+func TestChecksum(t *testing.T) {
+	h := Handshake{Version: PROTOCOL_VERSION, Caps: CAP_CHECKSUM}
+	buf := h.NewRequest(1, []byte("value"), WRITE)
+	buf[len(buf)-1] ^= 0xFF
+
+	if _, err := h.ParseRequest(bytes.NewReader(buf)); err != ErrChecksum {
+		t.Fatalf("校验失败: %v", err)
+	}
+}
+
+//TestFrameTooLarge 内容超过最大长度时不读取内容,返回ErrFrameTooLarge.
+func TestFrameTooLarge(t *testing.T) {
+	defer SetMaxFrameSize(MaxFrameSize())
+	SetMaxFrameSize(4)
+
+	_, err := ParseRequest(bytes.NewReader(NewRequest([]byte("12345"), WRITE)))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("超过最大长度: %v", err)
+	}
+	if err := CheckFrameSize(5); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("CheckFrameSize: %v", err)
+	}
+	if _, err := ParseRequest(bytes.NewReader(NewRequest([]byte("1234"), WRITE))); err != nil {
+		t.Fatal(err)
+	}
+}
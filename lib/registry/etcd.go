@@ -0,0 +1,301 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/etcd"
+	"github.com/houzhongjian/bigcache/lib/utils"
+)
+
+//ETCD_TIMEOUT etcd单次请求的超时时间.
+const ETCD_TIMEOUT = 5 * time.Second
+
+//Etcd 使用etcd保存集群信息.
+//cache server保存在/cacheserver/<id>,插槽保存在/slot/<id>,租约按类型保存在各自的前缀下.
+//插槽的epoch为etcd中的修改版本号,所有插槽共用etcd的版本号,每次修改都会增大.
+type Etcd struct {
+	Client *clientv3.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+//NewEtcd 连接etcd,多个地址使用逗号分隔.
+func NewEtcd(addr string) (*Etcd, error) {
+	cli := etcd.New(addr)
+	if cli == nil {
+		return nil, errors.New("etcd连接失败")
+	}
+	return NewEtcdWithClient(cli), nil
+}
+
+//NewEtcdWithClient 使用已经建立的etcd连接,Close时关闭连接.
+func NewEtcdWithClient(cli *clientv3.Client) *Etcd {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Etcd{Client: cli, ctx: ctx, cancel: cancel}
+}
+
+//leasePrefix 租约类型对应的key前缀.
+func leasePrefix(kind LeaseKind) string {
+	switch kind {
+	case LEASE_NODE:
+		return etcd.ALIVE_PREFIX
+	case LEASE_PROXY:
+		return etcd.PROXY_PREFIX
+	}
+	return fmt.Sprintf("/lease/%s/", kind)
+}
+
+func (e *Etcd) SlotCount(count uint32) (uint32, error) {
+	return etcd.LoadSlotCount(e.Client, count)
+}
+
+func (e *Etcd) Nodes() (list []base.CacheServer, err error) {
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+
+	response, err := e.Client.Get(ctx, "/cacheserver/", clientv3.WithPrefix())
+	if err != nil {
+		return list, err
+	}
+
+	for _, v := range response.Kvs {
+		cacheServer := base.CacheServer{}
+		if err := json.Unmarshal(v.Value, &cacheServer); err != nil {
+			return list, err
+		}
+		list = append(list, cacheServer)
+	}
+	return list, nil
+}
+
+func (e *Etcd) PutNode(node base.CacheServer) error {
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+	_, err = e.Client.Put(ctx, fmt.Sprintf("/cacheserver/%d", node.ID), string(b))
+	return err
+}
+
+//WatchNodes 监听cache server的变化.
+//删除事件中没有value,需要从删除之前的值中获取节点的信息.
+func (e *Etcd) WatchNodes() <-chan NodeEvent {
+	ch := make(chan NodeEvent, 128)
+	go func() {
+		defer close(ch)
+		for e.ctx.Err() == nil {
+			rch := e.Client.Watch(e.ctx, "/cacheserver/", clientv3.WithPrefix(), clientv3.WithPrevKV())
+			for wresp := range rch {
+				for _, ev := range wresp.Events {
+					event := NodeEvent{Type: EVENT_PUT}
+					kv := ev.Kv
+					if ev.Type == clientv3.EventTypeDelete {
+						if ev.PrevKv == nil {
+							continue
+						}
+						event.Type = EVENT_DELETE
+						kv = ev.PrevKv
+					}
+
+					if err := json.Unmarshal(kv.Value, &event.Node); err != nil {
+						log.Printf("err:%+v\n", err)
+						continue
+					}
+					ch <- event
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+func (e *Etcd) Slots() (list []base.Slot, rev int64, err error) {
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+
+	response, err := e.Client.Get(ctx, "/slot/", clientv3.WithPrefix())
+	if err != nil {
+		return list, rev, err
+	}
+
+	for _, v := range response.Kvs {
+		slot := base.Slot{}
+		if err := json.Unmarshal(v.Value, &slot); err != nil {
+			return list, rev, err
+		}
+		slot.Epoch = uint64(v.ModRevision)
+		list = append(list, slot)
+	}
+	return list, response.Header.Revision, nil
+}
+
+func (e *Etcd) GetSlot(id int) (slot base.Slot, err error) {
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+
+	response, err := e.Client.Get(ctx, fmt.Sprintf("/slot/%d", id))
+	if err != nil {
+		return slot, err
+	}
+	if len(response.Kvs) < 1 {
+		return slot, fmt.Errorf("插槽%d未分配", id)
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &slot)
+	slot.Epoch = uint64(response.Kvs[0].ModRevision)
+	return slot, err
+}
+
+func (e *Etcd) PutSlot(slot base.Slot) error {
+	b, err := json.Marshal(slot)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+	_, err = e.Client.Put(ctx, fmt.Sprintf("/slot/%d", slot.ID), string(b))
+	return err
+}
+
+//WatchSlots 从版本号rev之后开始监听,保证不会遗漏中间的变化.
+//版本已被压缩等异常情况时重新加载所有插槽.
+func (e *Etcd) WatchSlots(rev int64) <-chan SlotEvent {
+	ch := make(chan SlotEvent, 1024)
+	go func() {
+		defer close(ch)
+		for e.ctx.Err() == nil {
+			rch := e.Client.Watch(e.ctx, "/slot/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+			for wresp := range rch {
+				if err := wresp.Err(); err != nil {
+					log.Printf("err:%+v\n", err)
+					rev = e.reloadSlots(ch, rev)
+					break
+				}
+				rev = wresp.Header.Revision
+
+				for _, ev := range wresp.Events {
+					if ev.Type == clientv3.EventTypeDelete {
+						slotid := utils.ParseInt(strings.TrimPrefix(string(ev.Kv.Key), "/slot/"))
+						ch <- SlotEvent{Type: EVENT_DELETE, Slot: base.Slot{ID: slotid, Epoch: uint64(ev.Kv.ModRevision)}}
+						continue
+					}
+
+					event := SlotEvent{Type: EVENT_PUT}
+					if err := json.Unmarshal(ev.Kv.Value, &event.Slot); err != nil {
+						log.Printf("err:%+v\n", err)
+						continue
+					}
+					event.Slot.Epoch = uint64(ev.Kv.ModRevision)
+					ch <- event
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+//reloadSlots 重新加载所有插槽并推送EVENT_RELOAD,失败时返回原来的版本号.
+//中间被删除的插槽不会出现在监听中,因此需要替换整个路由表.
+func (e *Etcd) reloadSlots(ch chan SlotEvent, rev int64) int64 {
+	list, n, err := e.Slots()
+	if err != nil {
+		log.Printf("err:%+v\n", err)
+		time.Sleep(time.Second)
+		return rev
+	}
+
+	ch <- SlotEvent{Type: EVENT_RELOAD, Slots: list}
+	return n
+}
+
+func (e *Etcd) Grant(kind LeaseKind, name, value string, ttl int64) (Lease, error) {
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+
+	lease, err := e.Client.Grant(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &etcdLease{
+		cli:  e.Client,
+		id:   lease.ID,
+		key:  leasePrefix(kind) + name,
+		done: make(chan struct{}),
+	}
+	if err := l.Update(value); err != nil {
+		return nil, err
+	}
+
+	ch, err := e.Client.KeepAlive(e.ctx, lease.ID)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for range ch {
+		}
+		close(l.done)
+	}()
+	return l, nil
+}
+
+func (e *Etcd) Leases(kind LeaseKind) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(e.ctx, ETCD_TIMEOUT)
+	defer cancel()
+
+	prefix := leasePrefix(kind)
+	response, err := e.Client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make(map[string]string)
+	for _, v := range response.Kvs {
+		leases[strings.TrimPrefix(string(v.Key), prefix)] = string(v.Value)
+	}
+	return leases, nil
+}
+
+func (e *Etcd) Close() error {
+	e.cancel()
+	return e.Client.Close()
+}
+
+//etcdLease etcd中绑定租约的key.
+type etcdLease struct {
+	cli  *clientv3.Client
+	id   clientv3.LeaseID
+	key  string
+	done chan struct{}
+}
+
+func (l *etcdLease) Update(value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ETCD_TIMEOUT)
+	defer cancel()
+	_, err := l.cli.Put(ctx, l.key, value, clientv3.WithLease(l.id))
+	return err
+}
+
+func (l *etcdLease) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *etcdLease) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), ETCD_TIMEOUT)
+	defer cancel()
+	_, err := l.cli.Revoke(ctx, l.id)
+	return err
+}
@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/gossip"
+)
+
+//集群状态中的key.
+const (
+	GOSSIP_SLOT_COUNT_KEY = "slotcount"
+	GOSSIP_NODE_PREFIX    = "node/"
+	GOSSIP_SLOT_PREFIX    = "slot/"
+)
+
+//Gossip 不依赖外部组件,集群成员之间通过gossip协议交换信息.
+//cache server和插槽保存在带Epoch的集群状态中,插槽的epoch即集群状态中的Epoch,租约保存在成员的注册信息中,成员下线之后租约失效.
+//多个成员同时修改同一项时Epoch较大的生效,修改插槽等操作应当只由一个admin执行.
+type Gossip struct {
+	g *gossip.Gossip
+}
+
+//NewGossip 开始监听并通过种子成员加入集群.
+func NewGossip(cfg gossip.Config) (*Gossip, error) {
+	g, err := gossip.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Join(); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return &Gossip{g: g}, nil
+}
+
+//Epoch 当前已知的集群状态的最大Epoch.
+func (r *Gossip) Epoch() uint64 {
+	return r.g.Epoch()
+}
+
+func (r *Gossip) SlotCount(count uint32) (uint32, error) {
+	if e, ok := r.g.Get(GOSSIP_SLOT_COUNT_KEY); ok {
+		n, err := strconv.ParseUint(e.Value, 10, 32)
+		return uint32(n), err
+	}
+
+	r.g.Put(GOSSIP_SLOT_COUNT_KEY, strconv.FormatUint(uint64(count), 10))
+	return count, nil
+}
+
+func (r *Gossip) Nodes() (list []base.CacheServer, err error) {
+	entries, _ := r.g.List(GOSSIP_NODE_PREFIX)
+	for _, e := range entries {
+		cacheServer := base.CacheServer{}
+		if err := json.Unmarshal([]byte(e.Value), &cacheServer); err != nil {
+			return list, err
+		}
+		list = append(list, cacheServer)
+	}
+	return list, nil
+}
+
+func (r *Gossip) PutNode(node base.CacheServer) error {
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	r.g.Put(fmt.Sprintf("%s%d", GOSSIP_NODE_PREFIX, node.ID), string(b))
+	return nil
+}
+
+func (r *Gossip) WatchNodes() <-chan NodeEvent {
+	_, version := r.g.List(GOSSIP_NODE_PREFIX)
+	wch := r.g.Watch(GOSSIP_NODE_PREFIX, version)
+
+	ch := make(chan NodeEvent, 128)
+	go func() {
+		defer close(ch)
+		for e := range wch {
+			event := NodeEvent{Type: EVENT_PUT}
+			if err := json.Unmarshal([]byte(e.Value), &event.Node); err != nil {
+				log.Printf("err:%+v\n", err)
+				continue
+			}
+			ch <- event
+		}
+	}()
+	return ch
+}
+
+func (r *Gossip) Slots() (list []base.Slot, rev int64, err error) {
+	entries, version := r.g.List(GOSSIP_SLOT_PREFIX)
+	for _, e := range entries {
+		slot := base.Slot{}
+		if err := json.Unmarshal([]byte(e.Value), &slot); err != nil {
+			return list, rev, err
+		}
+		slot.Epoch = e.Epoch
+		list = append(list, slot)
+	}
+	return list, int64(version), nil
+}
+
+func (r *Gossip) GetSlot(id int) (slot base.Slot, err error) {
+	e, ok := r.g.Get(fmt.Sprintf("%s%d", GOSSIP_SLOT_PREFIX, id))
+	if !ok {
+		return slot, fmt.Errorf("插槽%d未分配", id)
+	}
+
+	err = json.Unmarshal([]byte(e.Value), &slot)
+	slot.Epoch = e.Epoch
+	return slot, err
+}
+
+func (r *Gossip) PutSlot(slot base.Slot) error {
+	b, err := json.Marshal(slot)
+	if err != nil {
+		return err
+	}
+
+	r.g.Put(fmt.Sprintf("%s%d", GOSSIP_SLOT_PREFIX, slot.ID), string(b))
+	return nil
+}
+
+//WatchSlots rev为Slots返回的本地版本号,之后有变化时先推送所有插槽.
+func (r *Gossip) WatchSlots(rev int64) <-chan SlotEvent {
+	wch := r.g.Watch(GOSSIP_SLOT_PREFIX, uint64(rev))
+
+	ch := make(chan SlotEvent, 1024)
+	go func() {
+		defer close(ch)
+		for e := range wch {
+			event := SlotEvent{Type: EVENT_PUT}
+			if err := json.Unmarshal([]byte(e.Value), &event.Slot); err != nil {
+				log.Printf("err:%+v\n", err)
+				continue
+			}
+			event.Slot.Epoch = e.Epoch
+			ch <- event
+		}
+	}()
+	return ch
+}
+
+//Grant 注册信息保存在当前成员中,ttl不生效,成员被探测为下线之后其他成员不再返回.
+func (r *Gossip) Grant(kind LeaseKind, name, value string, ttl int64) (Lease, error) {
+	select {
+	case <-r.g.Done():
+		return nil, gossip.ErrClosed
+	default:
+	}
+
+	l := &gossipLease{g: r.g, key: fmt.Sprintf("%s/%s", kind, name)}
+	err := l.Update(value)
+	return l, err
+}
+
+//Leases 获取存活及疑似下线的成员中某一类型的注册信息.
+func (r *Gossip) Leases(kind LeaseKind) (map[string]string, error) {
+	prefix := fmt.Sprintf("%s/", kind)
+	leases := make(map[string]string)
+	for _, m := range r.g.Members() {
+		if m.State == gossip.STATE_DEAD {
+			continue
+		}
+		for k, v := range m.Meta {
+			if strings.HasPrefix(k, prefix) {
+				leases[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+	}
+	return leases, nil
+}
+
+func (r *Gossip) Close() error {
+	return r.g.Close()
+}
+
+//gossipLease 当前成员的一项注册信息.
+type gossipLease struct {
+	g   *gossip.Gossip
+	key string
+}
+
+func (l *gossipLease) Update(value string) error {
+	l.g.SetMeta(l.key, value)
+	return nil
+}
+
+func (l *gossipLease) Done() <-chan struct{} {
+	return l.g.Done()
+}
+
+func (l *gossipLease) Close() error {
+	l.g.DeleteMeta(l.key)
+	return nil
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/houzhongjian/bigcache/base"
+	"github.com/houzhongjian/bigcache/lib/conf"
+	"github.com/houzhongjian/bigcache/lib/gossip"
+)
+
+//EventType 注册信息变化的类型.
+type EventType int
+
+const (
+	EVENT_PUT    EventType = 1 //新增或修改.
+	EVENT_DELETE EventType = 2 //删除.
+	EVENT_RELOAD EventType = 3 //重新加载,用全部插槽替换整个路由表.
+)
+
+//NodeEvent cache server的变化.
+type NodeEvent struct {
+	Type EventType
+	Node base.CacheServer
+}
+
+//SlotEvent 插槽的变化,删除时只有插槽id,重新加载时Slots为全部插槽.
+type SlotEvent struct {
+	Type  EventType
+	Slot  base.Slot
+	Slots []base.Slot
+}
+
+//LeaseKind 租约的类型,同一类型下以名称区分.
+type LeaseKind string
+
+const (
+	LEASE_NODE  LeaseKind = "node"  //cache server的存活状态,名称为ip.
+	LEASE_PROXY LeaseKind = "proxy" //proxy的注册信息,名称为地址.
+)
+
+var ErrNoRegistry = errors.New("没有配置etcd_addr、gossip_addr或registry_file")
+
+//Lease 绑定租约的注册信息,停止续约之后自动删除.
+type Lease interface {
+	//Update 更新注册信息.
+	Update(value string) error
+	//Done 租约过期或者连接中断时关闭.
+	Done() <-chan struct{}
+	//Close 撤销租约并删除注册信息.
+	Close() error
+}
+
+//Registry 集群信息的存储,保存cache server、插槽以及proxy的注册信息.
+//watch返回的channel在Close之前一直有效,连接中断时由实现负责重新监听.
+type Registry interface {
+	//SlotCount 获取集群的插槽数量,不存在时写入count.
+	SlotCount(count uint32) (uint32, error)
+
+	//Nodes 获取所有的cache server.
+	Nodes() ([]base.CacheServer, error)
+	//PutNode 写入cache server的信息.
+	PutNode(node base.CacheServer) error
+	//WatchNodes 监听cache server的变化.
+	WatchNodes() <-chan NodeEvent
+
+	//Slots 获取所有的插槽,同时返回当前的版本号,用于监听之后的变化.
+	Slots() (list []base.Slot, rev int64, err error)
+	//GetSlot 获取一个插槽.
+	GetSlot(id int) (base.Slot, error)
+	//PutSlot 写入插槽信息.
+	PutSlot(slot base.Slot) error
+	//WatchSlots 监听版本号rev之后插槽的变化.
+	//无法保证不遗漏变化时推送EVENT_RELOAD,监听方需要用其中的插槽替换整个路由表.
+	WatchSlots(rev int64) <-chan SlotEvent
+
+	//Grant 创建租约并写入注册信息,ttl为过期时间,单位秒.
+	Grant(kind LeaseKind, name, value string, ttl int64) (Lease, error)
+	//Leases 获取某一类型所有未过期的注册信息,key为名称.
+	Leases(kind LeaseKind) (map[string]string, error)
+
+	Close() error
+}
+
+//New 根据配置文件创建Registry.
+//配置了registry_file时使用静态文件,其次配置了gossip_addr时使用gossip,否则使用etcd_addr.
+func New() (Registry, error) {
+	if path := conf.GetString("registry_file"); len(path) > 0 {
+		return NewStatic(path)
+	}
+	if addr := conf.GetString("gossip_addr"); len(addr) > 0 {
+		return NewGossip(gossipConfig(addr))
+	}
+	if addr := conf.GetString("etcd_addr"); len(addr) > 0 {
+		return NewEtcd(addr)
+	}
+	return nil, ErrNoRegistry
+}
+
+//gossipConfig 从配置文件读取gossip的配置,种子成员使用逗号分隔.
+func gossipConfig(addr string) gossip.Config {
+	cfg := gossip.Config{
+		Addr:           addr,
+		Secret:         conf.GetString("auth_secret"),
+		ProbeInterval:  time.Duration(conf.GetInt("gossip_probe_interval")) * time.Millisecond,
+		SuspectTimeout: time.Duration(conf.GetInt("gossip_suspect_timeout")) * time.Millisecond,
+	}
+	for _, seed := range strings.Split(conf.GetString("gossip_seeds"), ",") {
+		if seed = strings.TrimSpace(seed); len(seed) > 0 {
+			cfg.Seeds = append(cfg.Seeds, seed)
+		}
+	}
+	return cfg
+}
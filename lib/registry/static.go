@@ -0,0 +1,504 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/houzhongjian/bigcache/base"
+)
+
+//STATIC_RELOAD_INTERVAL 检查静态文件是否被修改的间隔.
+const STATIC_RELOAD_INTERVAL = time.Second
+
+//StaticFile 静态文件的内容.
+//插槽按范围保存,连续且信息、epoch都相同的插槽合并为一个范围.
+type StaticFile struct {
+	SlotCount uint32
+	Nodes     []base.CacheServer
+	Slots     []SlotRange
+	Proxies   []string //可用的proxy地址.
+}
+
+//SlotRange 一段连续的插槽.
+type SlotRange struct {
+	Start    int
+	End      int
+	Types    base.SlotType `json:",omitempty"`
+	IP       string
+	NewIP    string   `json:",omitempty"`
+	Replicas []string `json:",omitempty"`
+	Epoch    uint64   `json:",omitempty"` //修改插槽时递增,手动修改文件中的插槽时需要改为大于文件中所有插槽的epoch.
+}
+
+//Static 使用本地的静态文件保存集群信息,用于不部署etcd的小规模集群和测试.
+//修改时持有文件锁,重新读取文件之后修改并写回,同一台机器上的其他进程定时检查文件的修改时间并重新加载.
+//静态文件没有跨进程的租约,文件中的cache server和proxy视为一直存活,租约只在当前进程中有效.
+//插槽的epoch保存在文件中,修改插槽时使用文件中最大的epoch加1,所有进程看到的epoch一致且只增不减.
+type Static struct {
+	path    string
+	lock    *sync.Mutex
+	modTime time.Time
+	rev     int64 //当前进程中插槽的版本号,用于WatchSlots.
+	count   uint32
+	nodes   map[uint]base.CacheServer
+	slots   map[int]base.Slot
+	proxies []string
+	leases  map[LeaseKind]map[string]string
+
+	nodeWatchers []chan NodeEvent
+	slotWatchers []chan SlotEvent
+	stop         chan struct{}
+}
+
+//NewStatic 加载静态文件,文件不存在时创建.
+func NewStatic(path string) (*Static, error) {
+	s := &Static{
+		path:   path,
+		lock:   &sync.Mutex{},
+		nodes:  make(map[uint]base.CacheServer),
+		slots:  make(map[int]base.Slot),
+		leases: make(map[LeaseKind]map[string]string),
+		stop:   make(chan struct{}),
+	}
+
+	err := s.flock(func() error {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return s.save()
+		}
+		_, _, err := s.reload()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go s.watchFile()
+	return s, nil
+}
+
+//reload 重新加载静态文件,返回与之前相比发生变化的cache server和插槽,调用时需要持有锁.
+func (s *Static) reload() (nodeEvents []NodeEvent, slotEvents []SlotEvent, err error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file := StaticFile{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	nodes := make(map[uint]base.CacheServer)
+	for _, node := range file.Nodes {
+		if node.Types == 0 {
+			node.Types = base.CACHESERVER_TYPE_NORMAL
+		}
+		nodes[node.ID] = node
+	}
+	slots := make(map[int]base.Slot)
+	var epoch uint64
+	for _, r := range file.Slots {
+		if r.Epoch > epoch {
+			epoch = r.Epoch
+		}
+		for id := r.Start; id <= r.End; id++ {
+			slots[id] = r.slot(id)
+		}
+	}
+
+	for id, node := range nodes {
+		if old, ok := s.nodes[id]; !ok || !reflect.DeepEqual(old, node) {
+			nodeEvents = append(nodeEvents, NodeEvent{Type: EVENT_PUT, Node: node})
+		}
+	}
+	for id, node := range s.nodes {
+		if _, ok := nodes[id]; !ok {
+			nodeEvents = append(nodeEvents, NodeEvent{Type: EVENT_DELETE, Node: node})
+		}
+	}
+	for id, slot := range slots {
+		if old, ok := s.slots[id]; !ok || !reflect.DeepEqual(old, slot) {
+			slotEvents = append(slotEvents, SlotEvent{Type: EVENT_PUT, Slot: slot})
+		}
+	}
+	//删除的插槽没有epoch,使用文件中最大的epoch.
+	for id := range s.slots {
+		if _, ok := slots[id]; !ok {
+			slotEvents = append(slotEvents, SlotEvent{Type: EVENT_DELETE, Slot: base.Slot{ID: id, Epoch: epoch}})
+		}
+	}
+
+	s.modTime = info.ModTime()
+	s.count = file.SlotCount
+	s.nodes = nodes
+	s.slots = slots
+	s.proxies = file.Proxies
+	if len(slotEvents) > 0 {
+		s.rev++
+	}
+	return nodeEvents, slotEvents, nil
+}
+
+//slot 范围中的一个插槽.
+func (r SlotRange) slot(id int) base.Slot {
+	slot := base.Slot{ID: id, Types: r.Types, IP: r.IP, NewIP: r.NewIP, Replicas: r.Replicas, Epoch: r.Epoch}
+	if slot.Types == 0 {
+		slot.Types = base.SLOT_TYPE_NORMAL
+	}
+	return slot
+}
+
+//save 将当前的信息写入静态文件,先写入临时文件再替换,调用时需要持有锁.
+func (s *Static) save() error {
+	file := StaticFile{SlotCount: s.count, Proxies: s.proxies}
+	for _, node := range s.nodes {
+		file.Nodes = append(file.Nodes, node)
+	}
+	sort.Slice(file.Nodes, func(i, j int) bool {
+		return file.Nodes[i].ID < file.Nodes[j].ID
+	})
+
+	ids := make([]int, 0, len(s.slots))
+	for id := range s.slots {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		slot := s.slots[id]
+		r := SlotRange{Start: id, End: id, Types: slot.Types, IP: slot.IP, NewIP: slot.NewIP, Replicas: slot.Replicas, Epoch: slot.Epoch}
+		if n := len(file.Slots); n > 0 {
+			last := &file.Slots[n-1]
+			if last.End+1 == id && reflect.DeepEqual(last.slot(id), r.slot(id)) {
+				last.End = id
+				continue
+			}
+		}
+		file.Slots = append(file.Slots, r)
+	}
+
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+//flock 持有文件锁执行fn,多个进程同时修改静态文件时依次执行,调用时需要持有锁.
+func (s *Static) flock(fn func() error) error {
+	path := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+//update 重新读取静态文件,执行fn修改之后写回,期间持有文件锁,调用时需要持有锁.
+//重新读取时其他进程的修改也会推送.
+func (s *Static) update(fn func()) error {
+	return s.flock(func() error {
+		nodeEvents, slotEvents, err := s.reload()
+		if err != nil {
+			return err
+		}
+		s.notify(nodeEvents, slotEvents)
+
+		fn()
+		return s.save()
+	})
+}
+
+//maxEpoch 所有插槽中最大的epoch,调用时需要持有锁.
+func (s *Static) maxEpoch() (epoch uint64) {
+	for _, slot := range s.slots {
+		if slot.Epoch > epoch {
+			epoch = slot.Epoch
+		}
+	}
+	return epoch
+}
+
+//watchFile 定时检查静态文件是否被其他进程修改.
+func (s *Static) watchFile() {
+	ticker := time.NewTicker(STATIC_RELOAD_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.lock.Lock()
+		info, err := os.Stat(s.path)
+		if err != nil || info.ModTime().Equal(s.modTime) {
+			s.lock.Unlock()
+			continue
+		}
+
+		nodeEvents, slotEvents, err := s.reload()
+		if err != nil {
+			log.Printf("err:%+v\n", err)
+			s.lock.Unlock()
+			continue
+		}
+		s.notify(nodeEvents, slotEvents)
+		s.lock.Unlock()
+	}
+}
+
+//notify 推送变化,调用时需要持有锁.
+func (s *Static) notify(nodeEvents []NodeEvent, slotEvents []SlotEvent) {
+	for _, ch := range s.nodeWatchers {
+		for _, ev := range nodeEvents {
+			ch <- ev
+		}
+	}
+	for _, ch := range s.slotWatchers {
+		for _, ev := range slotEvents {
+			ch <- ev
+		}
+	}
+}
+
+//SlotCount 文件中没有插槽数量时写入count.
+func (s *Static) SlotCount(count uint32) (uint32, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.count > 0 {
+		if s.count != count {
+			log.Println("配置的插槽数量:", count, "与集群的插槽数量:", s.count, "不一致, 使用集群的插槽数量")
+		}
+		return s.count, nil
+	}
+
+	err := s.update(func() {
+		//重新读取之后其他进程已经写入时以文件中的为准.
+		if s.count == 0 {
+			s.count = count
+		}
+	})
+	return s.count, err
+}
+
+func (s *Static) Nodes() ([]base.CacheServer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]base.CacheServer, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		list = append(list, node)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (s *Static) PutNode(node base.CacheServer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.update(func() {
+		s.nodes[node.ID] = node
+	})
+	if err != nil {
+		return err
+	}
+	s.notify([]NodeEvent{{Type: EVENT_PUT, Node: node}}, nil)
+	return nil
+}
+
+//WatchNodes channel需要及时读取,否则会阻塞其他的修改.
+func (s *Static) WatchNodes() <-chan NodeEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ch := make(chan NodeEvent, 128)
+	s.nodeWatchers = append(s.nodeWatchers, ch)
+	return ch
+}
+
+func (s *Static) Slots() (list []base.Slot, rev int64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list = make([]base.Slot, 0, len(s.slots))
+	for _, slot := range s.slots {
+		list = append(list, slot)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, s.rev, nil
+}
+
+func (s *Static) GetSlot(id int) (base.Slot, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	slot, ok := s.slots[id]
+	if !ok {
+		return slot, fmt.Errorf("插槽%d未分配", id)
+	}
+	return slot, nil
+}
+
+func (s *Static) PutSlot(slot base.Slot) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.update(func() {
+		slot.Epoch = s.maxEpoch() + 1
+		s.slots[slot.ID] = slot
+	})
+	if err != nil {
+		return err
+	}
+	s.rev++
+	s.notify(nil, []SlotEvent{{Type: EVENT_PUT, Slot: slot}})
+	return nil
+}
+
+//WatchSlots 版本号rev之后有变化时先推送EVENT_RELOAD.
+func (s *Static) WatchSlots(rev int64) <-chan SlotEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ch := make(chan SlotEvent, 1024)
+	if rev < s.rev {
+		list := make([]base.Slot, 0, len(s.slots))
+		for _, slot := range s.slots {
+			list = append(list, slot)
+		}
+		ch <- SlotEvent{Type: EVENT_RELOAD, Slots: list}
+	}
+	s.slotWatchers = append(s.slotWatchers, ch)
+	return ch
+}
+
+//Grant 租约只在当前进程中有效,不会过期.
+func (s *Static) Grant(kind LeaseKind, name, value string, ttl int64) (Lease, error) {
+	l := &staticLease{s: s, kind: kind, name: name, done: make(chan struct{})}
+	if err := l.Update(value); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+//Leases 文件中的cache server和proxy视为一直存活,与当前进程中的租约合并.
+func (s *Static) Leases(kind LeaseKind) (map[string]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	leases := make(map[string]string)
+	switch kind {
+	case LEASE_NODE:
+		for _, node := range s.nodes {
+			b, err := json.Marshal(node)
+			if err != nil {
+				return nil, err
+			}
+			leases[node.IP] = string(b)
+		}
+	case LEASE_PROXY:
+		for _, addr := range s.proxies {
+			b, err := json.Marshal(base.ProxyServer{Addr: addr})
+			if err != nil {
+				return nil, err
+			}
+			leases[addr] = string(b)
+		}
+	}
+
+	for name, value := range s.leases[kind] {
+		leases[name] = value
+	}
+	return leases, nil
+}
+
+func (s *Static) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+	close(s.stop)
+	for _, ch := range s.nodeWatchers {
+		close(ch)
+	}
+	for _, ch := range s.slotWatchers {
+		close(ch)
+	}
+	s.nodeWatchers, s.slotWatchers = nil, nil
+	return nil
+}
+
+//staticLease 当前进程中的租约.
+type staticLease struct {
+	s    *Static
+	kind LeaseKind
+	name string
+	done chan struct{}
+}
+
+func (l *staticLease) Update(value string) error {
+	l.s.lock.Lock()
+	defer l.s.lock.Unlock()
+
+	if l.s.leases[l.kind] == nil {
+		l.s.leases[l.kind] = make(map[string]string)
+	}
+	l.s.leases[l.kind][l.name] = value
+	return nil
+}
+
+func (l *staticLease) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *staticLease) Close() error {
+	l.s.lock.Lock()
+	defer l.s.lock.Unlock()
+
+	delete(l.s.leases[l.kind], l.name)
+	return nil
+}
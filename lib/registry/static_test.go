@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/houzhongjian/bigcache/base"
+)
+
+func newTestStatic(t *testing.T, path string) *Static {
+	s, err := NewStatic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+//TestStaticFile 加载手动编写的静态文件,插槽范围展开为单个插槽,修改之后写回文件.
+func TestStaticFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bigcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cluster.json")
+	file := StaticFile{
+		SlotCount: 16,
+		Nodes:     []base.CacheServer{{ID: 1, IP: "127.0.0.1:63780"}},
+		Slots:     []SlotRange{{Start: 0, End: 15, IP: "127.0.0.1:63780"}},
+	}
+	b, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStatic(t, path)
+	defer s.Close()
+	if count, err := s.SlotCount(1024); err != nil || count != 16 {
+		t.Fatalf("插槽数量: %d %v", count, err)
+	}
+	slots, _, err := s.Slots()
+	if err != nil || len(slots) != 16 {
+		t.Fatalf("插槽: %d %v", len(slots), err)
+	}
+	if slots[15].Types != base.SLOT_TYPE_NORMAL || slots[15].IP != "127.0.0.1:63780" {
+		t.Fatalf("插槽15: %+v", slots[15])
+	}
+
+	if err := s.PutNode(base.CacheServer{ID: 2, IP: "127.0.0.1:63781"}); err != nil {
+		t.Fatal(err)
+	}
+	lease, err := s.Grant(LEASE_PROXY, "127.0.0.1:6378", "{}", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leases, _ := s.Leases(LEASE_PROXY); len(leases) != 1 {
+		t.Fatalf("租约: %v", leases)
+	}
+	lease.Close()
+	if leases, _ := s.Leases(LEASE_PROXY); len(leases) != 0 {
+		t.Fatalf("撤销之后的租约: %v", leases)
+	}
+
+	//其他进程重新打开时读取写回的信息,租约只在当前进程中有效.
+	other := newTestStatic(t, path)
+	defer other.Close()
+	nodes, err := other.Nodes()
+	if err != nil || len(nodes) != 2 || nodes[1].IP != "127.0.0.1:63781" {
+		t.Fatalf("节点: %+v %v", nodes, err)
+	}
+	if leases, _ := other.Leases(LEASE_NODE); len(leases) != 2 {
+		t.Fatalf("节点的租约: %v", leases)
+	}
+}
+
+//TestStaticEpoch 多个进程修改同一个静态文件时,插槽的epoch一致且只增不减.
+func TestStaticEpoch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bigcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cluster.json")
+	a := newTestStatic(t, path)
+	defer a.Close()
+	b := newTestStatic(t, path)
+	defer b.Close()
+
+	if err := a.PutSlot(base.Slot{ID: 1, Types: base.SLOT_TYPE_NORMAL, IP: "127.0.0.1:63780"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := a.GetSlot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//b没有重新加载文件,修改时也要使用文件中的epoch.
+	if err := b.PutSlot(base.Slot{ID: 2, Types: base.SLOT_TYPE_NORMAL, IP: "127.0.0.1:63781"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PutSlot(base.Slot{ID: 1, Types: base.SLOT_TYPE_NORMAL, IP: "127.0.0.1:63781"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.GetSlot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Epoch <= first.Epoch {
+		t.Fatalf("epoch没有递增: %d -> %d", first.Epoch, second.Epoch)
+	}
+
+	//重新打开文件时读取保存的epoch.
+	c := newTestStatic(t, path)
+	defer c.Close()
+	slots, _, err := c.Slots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("插槽数量: %d", len(slots))
+	}
+	for _, slot := range slots {
+		got, err := b.GetSlot(slot.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slot.Epoch != got.Epoch {
+			t.Fatalf("插槽%d的epoch不一致: %d != %d", slot.ID, slot.Epoch, got.Epoch)
+		}
+	}
+}
@@ -3,15 +3,79 @@ package utils
 import (
 	"hash/crc32"
 	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
+//DEFAULT_SLOT_COUNT 默认插槽数量,与redis cluster保持一致.
+const DEFAULT_SLOT_COUNT = 16384
+
+//slotCount 集群的插槽数量.
+var slotCount uint32 = DEFAULT_SLOT_COUNT
+
+//crc16tab CRC16(XMODEM)查找表,与redis cluster使用的算法一致.
+var crc16tab [256]uint16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16tab[i] = crc
+	}
+}
+
 func CRC32(str string) uint32 {
 	return crc32.ChecksumIEEE([]byte(str))
 }
 
+//CRC16 计算CRC16(XMODEM)校验值.
+func CRC16(str string) uint16 {
+	var crc uint16
+	for i := 0; i < len(str); i++ {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^str[i]]
+	}
+	return crc
+}
+
+//SetSlotCount 设置集群的插槽数量,为0时使用默认值.
+func SetSlotCount(count uint32) {
+	if count == 0 {
+		count = DEFAULT_SLOT_COUNT
+	}
+	atomic.StoreUint32(&slotCount, count)
+}
+
+//SlotCount 获取集群的插槽数量.
+func SlotCount() uint32 {
+	return atomic.LoadUint32(&slotCount)
+}
+
+//HashTag 获取key中参与插槽计算的部分,规则与redis cluster一致.
+//key中包含{...}且花括号中的内容不为空时,只使用第一个{与其后第一个}之间的内容,
+//例如 user:{42}:profile 与 user:{42}:cart 会分配到同一个插槽.
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+	if end < 1 {
+		return key
+	}
+
+	return key[start+1 : start+1+end]
+}
+
 //计算key的插槽.
 func Slot(key string) uint32 {
-	return CRC32(key) % 3
+	return uint32(CRC16(HashTag(key))) % SlotCount()
 }
 
 //ParseInt.
@@ -0,0 +1,45 @@
+package utils
+
+import "testing"
+
+//TestSlot 与Redis Cluster相同的CRC16,插槽数量改变之后按新的数量取模.
+func TestSlot(t *testing.T) {
+	defer SetSlotCount(SlotCount())
+
+	if crc := CRC16("123456789"); crc != 0x31C3 {
+		t.Fatalf("CRC16: %x", crc)
+	}
+
+	SetSlotCount(16384)
+	if slot := Slot("123456789"); slot != 0x31C3 {
+		t.Fatalf("16384个插槽: %d", slot)
+	}
+	SetSlotCount(1024)
+	if slot := Slot("123456789"); slot != 0x31C3%1024 {
+		t.Fatalf("1024个插槽: %d", slot)
+	}
+}
+
+//TestHashTag 只使用第一个{}中不为空的部分计算插槽.
+func TestHashTag(t *testing.T) {
+	cases := map[string]string{
+		"foo":            "foo",
+		"{user1000}.a":   "user1000",
+		"a{user1000}b":   "user1000",
+		"{}.a":           "{}.a",
+		"{a}{b}":         "a",
+		"a{b":            "a{b",
+		"a}b{c}":         "c",
+		"{{user}}":       "{user",
+		"{user1000}.b{}": "user1000",
+	}
+	for key, tag := range cases {
+		if got := HashTag(key); got != tag {
+			t.Errorf("%s: %q, 期望 %q", key, got, tag)
+		}
+	}
+
+	if Slot("{user1000}.following") != Slot("{user1000}.followers") {
+		t.Fatal("相同hash tag的key不在同一个插槽")
+	}
+}